@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Bookmark is a named position in a file.
+type Bookmark struct {
+	Path string  `json:"path"`
+	Time float64 `json:"time"`
+}
+
+// Bookmarks keeps bookmarks by name. With a file they are read from it at
+// start and written back on every change, otherwise they only last as long as
+// we run.
+type Bookmarks struct {
+	file string
+
+	mtx sync.Mutex
+	m   map[string]Bookmark
+}
+
+// LoadBookmarks reads the bookmarks in file, if it exists yet. An empty file
+// name keeps them in memory only.
+func LoadBookmarks(file string) (*Bookmarks, error) {
+	bs := &Bookmarks{file: file, m: map[string]Bookmark{}}
+	if file == "" {
+		return bs, nil
+	}
+
+	if err := readJSONFile(file, &bs.m); err != nil {
+		return nil, err
+	}
+	return bs, nil
+}
+
+func (bs *Bookmarks) All() map[string]Bookmark {
+	bs.mtx.Lock()
+	defer bs.mtx.Unlock()
+
+	all := make(map[string]Bookmark, len(bs.m))
+	for name, b := range bs.m {
+		all[name] = b
+	}
+	return all
+}
+
+func (bs *Bookmarks) Get(name string) (Bookmark, error) {
+	bs.mtx.Lock()
+	defer bs.mtx.Unlock()
+
+	b, ok := bs.m[name]
+	if !ok {
+		return b, fmt.Errorf("%w: no bookmark called %q", ErrNotFound, name)
+	}
+	return b, nil
+}
+
+func (bs *Bookmarks) Set(name string, b Bookmark) error {
+	if name == "" {
+		return fmt.Errorf("%w: bookmarks need a name", ErrBadArgument)
+	}
+
+	bs.mtx.Lock()
+	defer bs.mtx.Unlock()
+
+	bs.m[name] = b
+	return bs.save()
+}
+
+// save must be called with mtx held.
+func (bs *Bookmarks) save() error {
+	if bs.file == "" {
+		return nil
+	}
+	return writeJSONFile(bs.file, bs.m)
+}
+
+// SaveBookmark remembers where we are in the current file as name.
+func (mc *MPVClient) SaveBookmark(bs *Bookmarks, name string) (Bookmark, error) {
+	var b Bookmark
+	var err error
+	if b.Path, err = mc.getString("path"); err != nil {
+		if unavailable(err) {
+			err = ErrIdle
+		}
+		return b, err
+	}
+	if b.Time, err = mc.getFloat("time-pos"); err != nil {
+		return b, err
+	}
+	return b, bs.Set(name, b)
+}
+
+// JumpToBookmark seeks to the bookmark called name, first loading its file if
+// that isn't the one playing.
+func (mc *MPVClient) JumpToBookmark(bs *Bookmarks, name string) error {
+	b, err := bs.Get(name)
+	if err != nil {
+		return err
+	}
+
+	seek := func(mc *MPVClient) error {
+		res, err := mc.Command("seek", b.Time, "absolute")
+		if err != nil {
+			return err
+		}
+		_, err = replyData(<-res)
+		return err
+	}
+
+	cur, err := mc.getString("path")
+	if err != nil && !unavailable(err) {
+		return err
+	}
+	if cur == b.Path {
+		return seek(mc)
+	}
+	return mc.LoadFileThen(b.Path, seek)
+}
+
+// readJSONFile decodes the JSON in file into v. A file that doesn't exist yet
+// leaves v as it was.
+func readJSONFile(file string, v interface{}) error {
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%s: %w", file, err)
+	}
+	return nil
+}
+
+// writeJSONFile replaces file with v as JSON, through a temporary file so a
+// crash can't leave half of it.
+func writeJSONFile(file string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(file), "."+filepath.Base(file)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Entry is a file or directory under the browse root. Path is relative to
+// the root, for asking for the next directory, File is the full path on the
+// mpv machine, for loading it.
+type Entry struct {
+	Name string `json:"name"`
+	Dir  bool   `json:"dir"`
+	Path string `json:"path"`
+	File string `json:"file"`
+}
+
+// Browse lists dir, taken relative to root. Nothing outside of root can be
+// listed, neither through .. nor through symlinks.
+func Browse(root, dir string) ([]Entry, error) {
+	root, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return nil, err
+	}
+	root, err = filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+
+	// Cleaning it as an absolute path eats any leading ..
+	rel := path.Clean("/" + filepath.ToSlash(dir))
+	full, err := filepath.EvalSymlinks(filepath.Join(root, filepath.FromSlash(rel)))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, rel)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// A symlink may still lead out. Not a prefix check on root, which
+	// already ends in a separator when it is / or D:\.
+	if up, err := filepath.Rel(root, full); err != nil || up == ".." || strings.HasPrefix(up, ".."+string(filepath.Separator)) {
+		return nil, fmt.Errorf("%w: %s is outside the browse root", ErrBadArgument, rel)
+	}
+
+	des, err := os.ReadDir(full)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]Entry, 0, len(des))
+	for _, de := range des {
+		entries = append(entries, Entry{
+			Name: de.Name(),
+			Dir:  de.IsDir(),
+			Path: path.Join(rel, de.Name()),
+			File: filepath.Join(full, de.Name()),
+		})
+	}
+
+	// Directories first, then by name.
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Dir != entries[j].Dir {
+			return entries[i].Dir
+		}
+		return entries[i].Name < entries[j].Name
+	})
+	return entries, nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+)
+
+// coalescer adds up the deltas it is given within a window and sends them as
+// one command, so mashing a button doesn't flood the pipe and overshoot.
+// Everyone who took part in a window gets the reply to the combined command.
+type coalescer struct {
+	window time.Duration
+	send   func(delta float64) (<-chan []byte, error)
+
+	mtx     sync.Mutex
+	sum     float64
+	waiters []chan []byte
+	timer   *time.Timer
+}
+
+func newCoalescer(window time.Duration, send func(float64) (<-chan []byte, error)) *coalescer {
+	return &coalescer{window: window, send: send}
+}
+
+// Add has the same signature as the commands, so it can be dropped in where
+// they are used. With no window the delta is sent straight away.
+func (c *coalescer) Add(delta float64) (<-chan []byte, error) {
+	if c.window <= 0 {
+		return c.send(delta)
+	}
+
+	ch := make(chan []byte, 1)
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.sum += delta
+	c.waiters = append(c.waiters, ch)
+	if c.timer == nil {
+		c.timer = time.AfterFunc(c.window, c.flush)
+	}
+
+	return ch, nil
+}
+
+func (c *coalescer) flush() {
+	c.mtx.Lock()
+	sum, waiters := c.sum, c.waiters
+	c.sum, c.waiters, c.timer = 0, nil, nil
+	c.mtx.Unlock()
+
+	var reply []byte
+	res, err := c.send(sum)
+	if err != nil {
+		log.Println(err)
+		reply, _ = json.Marshal(map[string]string{"error": err.Error()})
+	} else {
+		reply = <-res
+	}
+
+	for _, w := range waiters {
+		w <- reply
+		close(w)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+// commandLog writes every command we send and every reply we get as a line
+// of JSON, so a session can be replayed by sending the "send" lines again.
+// Commands sent for an HTTP request carry its trace id:
+//
+//	{"time":"...","dir":"send","trace":"3f2a9c0e1b7d4a65","msg":{"command":["cycle","pause"],"request_id":1}}
+//	{"time":"...","dir":"recv","trace":"3f2a9c0e1b7d4a65","msg":{"error":"success","request_id":1}}
+type commandLog struct {
+	mtx sync.Mutex
+	w   io.Writer
+}
+
+type commandLogEntry struct {
+	Time  time.Time       `json:"time"`
+	Dir   string          `json:"dir"`
+	Trace string          `json:"trace,omitempty"`
+	Msg   json.RawMessage `json:"msg"`
+}
+
+// record logs msg, with the trace id of the HTTP request it was sent for, or
+// is the reply to, if there was one.
+func (cl *commandLog) record(dir string, msg []byte, trace string) {
+	if cl == nil {
+		return
+	}
+
+	b, err := json.Marshal(commandLogEntry{Time: time.Now(), Dir: dir, Trace: trace, Msg: bytes.TrimSpace(msg)})
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	cl.mtx.Lock()
+	defer cl.mtx.Unlock()
+
+	if _, err := cl.w.Write(append(b, '\n')); err != nil {
+		log.Println(err)
+	}
+}
+
+// SetCommandLog has every command and reply appended to w from now on. It
+// must be called before the client is used.
+func (mc *MPVClient) SetCommandLog(w io.Writer) { mc.cmdLog = &commandLog{w: w} }
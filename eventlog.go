@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+)
+
+// eventLog keeps the last few events mpv sent, oldest first, for debugging.
+type eventLog struct {
+	mtx    sync.Mutex
+	events []json.RawMessage
+	next   int
+	full   bool
+}
+
+func newEventLog(size int) *eventLog {
+	if size < 0 {
+		size = 0
+	}
+	return &eventLog{events: make([]json.RawMessage, size)}
+}
+
+func (el *eventLog) add(event []byte) {
+	el.mtx.Lock()
+	defer el.mtx.Unlock()
+
+	if len(el.events) == 0 {
+		return
+	}
+	el.events[el.next] = append(json.RawMessage(nil), bytes.TrimSpace(event)...)
+	el.next = (el.next + 1) % len(el.events)
+	if el.next == 0 {
+		el.full = true
+	}
+}
+
+func (el *eventLog) list() []json.RawMessage {
+	el.mtx.Lock()
+	defer el.mtx.Unlock()
+
+	if !el.full {
+		return append([]json.RawMessage{}, el.events[:el.next]...)
+	}
+	return append(append([]json.RawMessage{}, el.events[el.next:]...), el.events[:el.next]...)
+}
+
+// SetEventHistory sets how many of the latest events are kept for
+// RecentEvents, dropping the ones kept so far.
+func (mc *MPVClient) SetEventHistory(n int) {
+	mc.subsMtx.Lock()
+	defer mc.subsMtx.Unlock()
+
+	mc.history = newEventLog(n)
+}
+
+// RecentEvents returns the latest events mpv sent, oldest first.
+func (mc *MPVClient) RecentEvents() []json.RawMessage {
+	mc.subsMtx.Lock()
+	el := mc.history
+	mc.subsMtx.Unlock()
+
+	return el.list()
+}
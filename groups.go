@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pressly/chi"
+)
+
+// groupIdleTimeout is how long the connection to a group member is kept
+// when no command has gone to it.
+const groupIdleTimeout = time.Minute
+
+// ParseGroups reads groups of instances given as
+// "living=mpv-left,mpv-right;bedroom=mpv-bed", keyed by group name.
+func ParseGroups(spec string) (map[string][]string, error) {
+	groups := map[string][]string{}
+	for _, def := range strings.Split(spec, ";") {
+		if strings.TrimSpace(def) == "" {
+			continue
+		}
+		name, members, ok := strings.Cut(def, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("bad group %q, want name=pipe,pipe", def)
+		}
+		for _, m := range strings.Split(members, ",") {
+			if m = strings.TrimSpace(m); m != "" {
+				groups[name] = append(groups[name], m)
+			}
+		}
+		if len(groups[name]) == 0 {
+			return nil, fmt.Errorf("group %q has no instances", name)
+		}
+	}
+	return groups, nil
+}
+
+// SetGroups sets the groups commands can be mirrored to, see ParseGroups.
+func (cm *ClientManager) SetGroups(groups map[string][]string) {
+	cm.mtx.Lock()
+	defer cm.mtx.Unlock()
+	cm.groups = groups
+}
+
+// GroupResult is how a command mirrored to a group went on one instance.
+// Error is empty if it worked.
+type GroupResult struct {
+	Instance string    `json:"instance"`
+	Error    string    `json:"error,omitempty"`
+	Reply    *Envelope `json:"reply,omitempty"`
+}
+
+// GroupRun runs f on every instance in the group at once, and waits for all
+// of them. Each instance gets a client of its own, separate from the one the
+// rest of the handlers use.
+func (cm *ClientManager) GroupRun(group string, f func(*MPVClient) (<-chan []byte, error)) ([]GroupResult, error) {
+	cm.mtx.Lock()
+	members, ok := cm.groups[group]
+	clients := make([]*MPVClient, len(members))
+	for i, m := range members {
+		clients[i] = cm.memberClient(pipePath(m))
+	}
+	cm.mtx.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: no group called %q", ErrNotFound, group)
+	}
+
+	results := make([]GroupResult, len(members))
+	var wg sync.WaitGroup
+	for i := range members {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i].Instance = members[i]
+
+			res, err := f(clients[i])
+			if err != nil {
+				results[i].Error = err.Error()
+				return
+			}
+			reply := <-res
+			env := newEnvelope(reply)
+			results[i].Reply = &env
+			if _, err := replyData(reply); err != nil {
+				results[i].Error = err.Error()
+			}
+		}(i)
+	}
+	wg.Wait()
+	return results, nil
+}
+
+// memberClient is the client for the instance at path, made the first time
+// it is needed. It must be called with mtx held.
+func (cm *ClientManager) memberClient(path string) *MPVClient {
+	if cm.clients == nil {
+		cm.clients = map[string]*MPVClient{}
+	}
+	mc, ok := cm.clients[path]
+	if !ok {
+		mc = NewLazyMPVClient(path, groupIdleTimeout)
+		cm.clients[path] = mc
+	}
+	return mc
+}
+
+// groupCommands are what can be sent to a group, by the name used in the
+// URL.
+var groupCommands = map[string]func(*MPVClient) (<-chan []byte, error){
+	"pauseToggle":  (*MPVClient).PauseToggle,
+	"pauseOn":      (*MPVClient).PauseOn,
+	"pauseOff":     (*MPVClient).PauseOff,
+	"stop":         (*MPVClient).Stop,
+	"seekStart":    (*MPVClient).SeekStart,
+	"playlistPrev": (*MPVClient).PlaylistPrev,
+	"playlistNext": (*MPVClient).PlaylistNext,
+	"chapterPrev":  (*MPVClient).ChapterPrev,
+	"chapterNext":  (*MPVClient).ChapterNext,
+}
+
+// groupHandler serves /api/group/:name/:command, answering with how it went
+// on each instance.
+func groupHandler(cm *ClientManager) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		command := chi.URLParam(r, "command")
+		f, ok := groupCommands[command]
+		if !ok {
+			httpError(w, r, fmt.Errorf("%w: no group command called %q", ErrNotFound, command))
+			return
+		}
+		jsonHandler(func(*MPVClient) (interface{}, error) { return cm.GroupRun(chi.URLParam(r, "name"), f) })(w, r)
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// pipeDir is where Windows keeps its named pipes.
+const pipeDir = `\\.\pipe\`
+
+// DiscoverPipes lists the named pipes whose names match pattern, like
+// "mpv*". Setting input-ipc-server=\\.\pipe\mpv-<something> in mpv.conf makes
+// every instance show up here.
+func DiscoverPipes(pattern string) ([]string, error) {
+	entries, err := os.ReadDir(pipeDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, e := range entries {
+		ok, err := filepath.Match(pattern, e.Name())
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Instance is an mpv instance we found a pipe for.
+type Instance struct {
+	Index    int    `json:"index"`
+	Name     string `json:"name"`
+	Friendly string `json:"friendly,omitempty"`
+	Path     string `json:"path"`
+	Active   bool   `json:"active"`
+}
+
+// ClientManager keeps track of the mpv instances we can control, and which
+// of them the client behind the HTTP handlers is currently talking to.
+type ClientManager struct {
+	mc      *MPVClient
+	pattern string
+	names   *InstanceNames
+
+	mtx    sync.Mutex
+	active string
+
+	// Groups of pipe names commands can be mirrored to, and the clients
+	// for their members.
+	groups  map[string][]string
+	clients map[string]*MPVClient
+}
+
+func NewClientManager(mc *MPVClient, names *InstanceNames, pipeName, pattern string) *ClientManager {
+	return &ClientManager{mc: mc, names: names, pattern: pattern, active: pipePath(pipeName)}
+}
+
+// pipePath turns a bare pipe name into the path we dial.
+func pipePath(name string) string {
+	if strings.HasPrefix(name, pipeDir) {
+		return name
+	}
+	return pipeDir + name
+}
+
+func (cm *ClientManager) Instances() ([]Instance, error) {
+	names, err := DiscoverPipes(cm.pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	cm.mtx.Lock()
+	defer cm.mtx.Unlock()
+
+	is := make([]Instance, 0, len(names))
+	for i, n := range names {
+		path := pipePath(n)
+		is = append(is, Instance{Index: i, Name: n, Friendly: cm.names.nameOf(path), Path: path, Active: path == cm.active})
+	}
+	return is, nil
+}
+
+// Select makes the instance with the given friendly or pipe name the one
+// commands go to.
+func (cm *ClientManager) Select(name string) error {
+	if name == "" {
+		return fmt.Errorf("%w: no instance given", ErrBadArgument)
+	}
+	path, ok := cm.names.Lookup(name)
+	if !ok {
+		path = pipePath(name)
+	}
+
+	cm.mtx.Lock()
+	defer cm.mtx.Unlock()
+
+	if err := cm.mc.SetPipe(path); err != nil {
+		return err
+	}
+	cm.active = path
+	return nil
+}
+
+// InstanceNames are friendly names for instances, like "living room" for
+// \\.\pipe\mpv-left. With a file they are read from it at start and written
+// back on every change.
+type InstanceNames struct {
+	file string
+
+	mtx sync.Mutex
+	m   map[string]string // friendly name to pipe path
+}
+
+// LoadInstanceNames reads the names in file, if it exists yet. An empty file
+// name keeps them in memory only.
+func LoadInstanceNames(file string) (*InstanceNames, error) {
+	ns := &InstanceNames{file: file, m: map[string]string{}}
+	if file == "" {
+		return ns, nil
+	}
+	if err := readJSONFile(file, &ns.m); err != nil {
+		return nil, err
+	}
+	for name, pipe := range ns.m {
+		ns.m[name] = pipePath(pipe)
+	}
+	return ns, nil
+}
+
+func (ns *InstanceNames) All() map[string]string {
+	ns.mtx.Lock()
+	defer ns.mtx.Unlock()
+
+	all := make(map[string]string, len(ns.m))
+	for name, pipe := range ns.m {
+		all[name] = pipe
+	}
+	return all
+}
+
+// Lookup returns the pipe path called name, if there is one.
+func (ns *InstanceNames) Lookup(name string) (string, bool) {
+	ns.mtx.Lock()
+	defer ns.mtx.Unlock()
+	pipe, ok := ns.m[name]
+	return pipe, ok
+}
+
+// nameOf returns the friendly name of the pipe at path, the first in order if
+// it has several.
+func (ns *InstanceNames) nameOf(path string) string {
+	ns.mtx.Lock()
+	defer ns.mtx.Unlock()
+
+	found := ""
+	for name, pipe := range ns.m {
+		if pipe == path && (found == "" || name < found) {
+			found = name
+		}
+	}
+	return found
+}
+
+// Set names the pipe, replacing what the name was for before.
+func (ns *InstanceNames) Set(name, pipe string) error {
+	if name == "" || pipe == "" {
+		return fmt.Errorf("%w: need both a name and a pipe", ErrBadArgument)
+	}
+
+	ns.mtx.Lock()
+	defer ns.mtx.Unlock()
+
+	ns.m[name] = pipePath(pipe)
+	return ns.save()
+}
+
+func (ns *InstanceNames) Rename(from, to string) error {
+	if to == "" {
+		return fmt.Errorf("%w: no new name given", ErrBadArgument)
+	}
+
+	ns.mtx.Lock()
+	defer ns.mtx.Unlock()
+
+	pipe, ok := ns.m[from]
+	if !ok {
+		return fmt.Errorf("%w: no instance called %q", ErrNotFound, from)
+	}
+	if _, taken := ns.m[to]; taken && to != from {
+		return fmt.Errorf("%w: %q is already taken", ErrBadArgument, to)
+	}
+	delete(ns.m, from)
+	ns.m[to] = pipe
+	return ns.save()
+}
+
+func (ns *InstanceNames) Remove(name string) error {
+	ns.mtx.Lock()
+	defer ns.mtx.Unlock()
+
+	if _, ok := ns.m[name]; !ok {
+		return fmt.Errorf("%w: no instance called %q", ErrNotFound, name)
+	}
+	delete(ns.m, name)
+	return ns.save()
+}
+
+// save must be called with mtx held.
+func (ns *InstanceNames) save() error {
+	if ns.file == "" {
+		return nil
+	}
+	return writeJSONFile(ns.file, ns.m)
+}
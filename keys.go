@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+var keyModifiers = map[string]bool{"SHIFT": true, "CTRL": true, "ALT": true, "META": true}
+
+// keyNames are mpv's names for keys that aren't a single character, see
+// "mpv --input-keylist". Letters and other printable characters are given as
+// themselves.
+var keyNames = map[string]bool{
+	"LEFT": true, "RIGHT": true, "UP": true, "DOWN": true,
+	"SPACE": true, "ENTER": true, "ESC": true, "TAB": true, "BS": true,
+	"DEL": true, "INS": true, "HOME": true, "END": true, "PGUP": true, "PGDWN": true,
+	"SHARP": true, "PRINT": true, "MENU": true, "POWER": true, "SLEEP": true,
+	"PLAY": true, "PAUSE": true, "PLAYPAUSE": true, "PLAYONLY": true, "PAUSEONLY": true,
+	"STOP": true, "FORWARD": true, "REWIND": true, "NEXT": true, "PREV": true,
+	"RECORD": true, "CHANNEL_UP": true, "CHANNEL_DOWN": true,
+	"VOLUME_UP": true, "VOLUME_DOWN": true, "MUTE": true,
+	"HOMEPAGE": true, "WWW": true, "MAIL": true, "FAVORITES": true, "SEARCH": true,
+	"CLOSE_WIN": true, "UNMAPPED": true, "ANY_UNICODE": true, "CANCEL": true,
+}
+
+var keyNameRe = regexp.MustCompile(`^(F([1-9]|1[0-9]|2[0-4])|KP([0-9]|_DEC|_DEL|_ENTER|_INS|_ADD|_SUBTRACT|_MULTIPLY|_DIVIDE)|MBTN_\w+|MOUSE_BTN[0-9]+(_DBL)?|WHEEL_(UP|DOWN|LEFT|RIGHT)|AXIS_(UP|DOWN|LEFT|RIGHT))$`)
+
+// checkKey tells if name is a key mpv understands, like "a", "RIGHT" or
+// "Ctrl+Shift+RIGHT". The modifiers are Shift, Ctrl, Alt and Meta, each at
+// most once, and "+" itself is given as the last key, as in "Ctrl++".
+func checkKey(name string) error {
+	key, mods := name, ""
+	if strings.HasSuffix(name, "++") {
+		key, mods = "+", strings.TrimSuffix(name, "++")
+	} else if i := strings.LastIndex(name, "+"); i > 0 {
+		key, mods = name[i+1:], name[:i]
+	}
+
+	if mods != "" {
+		seen := map[string]bool{}
+		for _, m := range strings.Split(mods, "+") {
+			m = strings.ToUpper(m)
+			if !keyModifiers[m] || seen[m] {
+				return fmt.Errorf("%w: bad modifier %q in key %q", ErrBadArgument, m, name)
+			}
+			seen[m] = true
+		}
+	}
+
+	if utf8.RuneCountInString(key) == 1 && strings.TrimSpace(key) != "" {
+		return nil
+	}
+	if up := strings.ToUpper(key); keyNames[up] || keyNameRe.MatchString(up) {
+		return nil
+	}
+	return fmt.Errorf("%w: unknown key %q", ErrBadArgument, name)
+}
+
+// queryKey undoes "+" in a key name having been decoded to a space, as it is
+// in a query string, so "?name=Ctrl+RIGHT" works as is. A space is never a
+// key of its own, mpv calls it SPACE.
+func queryKey(name string) string { return strings.ReplaceAll(name, " ", "+") }
+
+// KeyPress presses and releases a key, as if it was pressed on mpv's own
+// keyboard, so it does whatever mpv has it bound to.
+func (mc *MPVClient) KeyPress(name string) (<-chan []byte, error) {
+	if err := checkKey(name); err != nil {
+		return nil, err
+	}
+	return mc.Command("keypress", name)
+}
+
+// Binding is a single entry in mpv's "input-bindings" property.
+type Binding struct {
+	Key      string `json:"key"`
+	Cmd      string `json:"cmd"`
+	Section  string `json:"section"`
+	Priority int    `json:"priority"`
+	Comment  string `json:"comment,omitempty"`
+	Owner    string `json:"owner,omitempty"`
+	IsWeak   bool   `json:"is_weak"`
+}
+
+// GetInputBindings is every key binding mpv has, including ones that are
+// shadowed by others with a higher priority.
+func (mc *MPVClient) GetInputBindings() ([]Binding, error) {
+	data, err := mc.GetProperty("input-bindings")
+	if err != nil {
+		return nil, err
+	}
+
+	var bindings []Binding
+	if err := json.Unmarshal(data, &bindings); err != nil {
+		return nil, err
+	}
+	return bindings, nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// logLevels are the levels request_log_messages takes, from none at all to
+// the most verbose.
+var logLevels = []string{"no", "fatal", "error", "warn", "info", "v", "debug", "trace"}
+
+// RequestLogMessages has mpv send its log messages at level and above as
+// "log-message" events. "no" turns them off again. Like observations, this
+// is forgotten when the connection is.
+func (mc *MPVClient) RequestLogMessages(level string) (<-chan []byte, error) {
+	for _, l := range logLevels {
+		if level == l {
+			return mc.Command("request_log_messages", level)
+		}
+	}
+	return nil, fmt.Errorf("%w: log level must be one of %v, got %q", ErrBadArgument, logLevels, level)
+}
+
+// SubscribeLogs is Subscribe for the "log-message" events, which no other
+// subscriber gets and aren't kept in the event history.
+func (mc *MPVClient) SubscribeLogs() (<-chan []byte, func()) { return mc.subscribe(mc.logSubs) }
+
+// logsHandler streams mpv's log messages as server-sent events, until the
+// client goes away. Nothing comes until RequestLogMessages has been called.
+func logsHandler(mc *MPVClient) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		events, unsub := mc.SubscribeLogs()
+		defer unsub()
+
+		streamEvents(w, r, events, func([]byte) bool { return true })
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/pressly/chi"
+)
+
+// MacroStep is a single command in a macro, optionally run after a delay.
+type MacroStep struct {
+	Command []interface{} `json:"command"`
+	Delay   string        `json:"delay,omitempty"`
+
+	delay time.Duration
+}
+
+// Macros maps a name to the steps it runs, in order.
+type Macros map[string][]MacroStep
+
+// LoadMacros reads macros from a JSON file of the form
+//
+//	{"cinema": [{"command": ["set_property", "fullscreen", true]},
+//	            {"command": ["script-message", "osc-visibility", "never"], "delay": "100ms"}]}
+func LoadMacros(path string) (Macros, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var ms Macros
+	if err := json.NewDecoder(f).Decode(&ms); err != nil {
+		return nil, err
+	}
+
+	for name, steps := range ms {
+		for i := range steps {
+			if len(steps[i].Command) == 0 {
+				return nil, fmt.Errorf("macro %s: step %d has no command", name, i)
+			}
+			if steps[i].Delay == "" {
+				continue
+			}
+			if steps[i].delay, err = time.ParseDuration(steps[i].Delay); err != nil {
+				return nil, fmt.Errorf("macro %s: step %d: %v", name, i, err)
+			}
+		}
+	}
+
+	return ms, nil
+}
+
+// StepResult is how a single step of a macro went.
+type StepResult struct {
+	Command []interface{}   `json:"command"`
+	Data    json.RawMessage `json:"data,omitempty"`
+	Error   string          `json:"error,omitempty"`
+}
+
+// RunMacro runs each step in turn, waiting for its reply before moving on.
+// A failing step doesn't stop the ones after it.
+func (mc *MPVClient) RunMacro(steps []MacroStep) []StepResult {
+	results := make([]StepResult, 0, len(steps))
+	for _, step := range steps {
+		time.Sleep(step.delay)
+
+		sr := StepResult{Command: step.Command}
+		res, err := mc.Command(step.Command...)
+		if err == nil {
+			sr.Data, err = replyData(<-res)
+		}
+		if err != nil {
+			sr.Error = err.Error()
+		}
+		results = append(results, sr)
+	}
+	return results
+}
+
+func macroHandler(ms Macros) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := chi.URLParam(r, "name")
+		steps, ok := ms[name]
+		if !ok {
+			http.Error(w, "no such macro: "+name, http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(requestClient(r).RunMacro(steps))
+	}
+}
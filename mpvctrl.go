@@ -2,24 +2,34 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"math/rand"
 	"net"
 	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/Microsoft/go-winio"
 	"github.com/buger/jsonparser"
 	"github.com/pressly/chi"
 )
 
-// TODO(rhermes): List the content of \\.\pipe\ and filter over some pattern,
-// to get the current mpv instances, and present them as choices in the root
-// form. This will allow me to have multiple mpv instance open and also allow me
-// to set it up in my mpv conf, so that a named pipe is open automatically.
-
 // These don't have the the last few bytes, as we append a request_id.
 var (
 	JPC_PAUSE_ON      = []byte(`{"command": ["set_property", "pause", true]`)
@@ -29,40 +39,403 @@ var (
 	JPC_OSC_OFF = []byte(`{"command": ["script-message", "osc-visibility", "never"]`)
 	JPC_OSC_ON  = []byte(`{"command": ["script-message", "osc-visibility", "always"]`)
 
+	JPC_STATS_TOGGLE = []byte(`{"command": ["script-binding", "stats/display-stats-toggle"]`)
+
 	JPC_PLAYLIST_PREV = []byte(`{"command": ["playlist-prev"]`)
 	JPC_PLAYLIST_NEXT = []byte(`{"command": ["playlist-next"]`)
 
-	JPC_CHAPTER_PREV = []byte(`{"command": ["add", "chapter", -1]`)
-	JPC_CHAPTER_NEXT = []byte(`{"command": ["add", "chapter", 1]`)
-
 	JPC_PRESS_LEFT  = []byte(`{"command": ["keypress", "LEFT"]`)
 	JPC_PRESS_RIGHT = []byte(`{"command": ["keypress", "RIGHT"]`)
 )
 
+// MPVClient talks to mpv. Copies made with Traced share the connection and
+// everything else, and only differ in the trace id put on what they send.
 type MPVClient struct {
+	*clientState
+
+	// The trace id of the HTTP request commands are sent for, if any.
+	trace string
+}
+
+// Traced returns a client that logs what it sends, and the replies, with the
+// trace id in front.
+func (mc *MPVClient) Traced(id string) *MPVClient {
+	return &MPVClient{clientState: mc.clientState, trace: id}
+}
+
+type clientState struct {
 	nc net.Conn
 	rw *bufio.ReadWriter
 	wg sync.WaitGroup
 	rd *rand.Rand
 
+	// Closed when the inputMonitor reading from nc stops, after which
+	// nothing written to nc will ever be answered. Guarded by i2cMtx.
+	monitorDone chan struct{}
+
+	// How we get a connection, and how long one may sit unused before we
+	// let go of it. With no idle timeout we hold on to it forever.
+	dial        func() (net.Conn, error)
+	idleTimeout time.Duration
+	idleTimer   *time.Timer
+
+	// Who wants to hear about the connection coming and going.
+	wasConnected bool
+	states       []chan ConnState
+	statesMtx    sync.Mutex
+
+	// Where commands and replies are logged, if anywhere.
+	cmdLog *commandLog
+
+	// The longest line we will take from mpv, 0 for no limit.
+	maxLine atomic.Int64
+
+	// How long a command waits for its reply, 0 for no limit.
+	replyTimeout atomic.Int64
+
+	// The last id handed out for observing a property, and where the
+	// changes go for those observed through ObserveMany.
+	observeID   atomic.Int64
+	observed    map[int64]chan []byte
+	observedMtx sync.Mutex
+
+	// How many properties aggregate calls ask for at once, 0 for the default.
+	statusConcurrency atomic.Int64
+
+	// If mpv is in the middle of a seek, kept up to date by WatchSeeking.
+	seeking atomic.Bool
+
+	// The osc-visibility we last set, as the osc doesn't let us read it.
+	// Empty until we set one on this instance.
+	oscMode string
+	oscMtx  sync.Mutex
+
 	// This is used for routing
-	i2c    map[uint32](chan []byte)
+	i2c    map[uint32]*pending
 	i2cMtx sync.Mutex
+
+	// Set by Close, under i2cMtx.
+	closed bool
+
+	// How many commands may wait for a reply at once, 0 for no limit.
+	// Guarded by i2cMtx.
+	maxInflight int
+
+	// Everyone who wants to hear about events, and the latest of them.
+	// mpv's log messages only go to logSubs, so they can't crowd out the
+	// events everyone else is waiting for.
+	subs    map[chan []byte]struct{}
+	logSubs map[chan []byte]struct{}
+	history *eventLog
+	subsMtx sync.Mutex
+
+	// Commands waiting for the writer, what decides the queue they go in
+	// and what happens when they fail to be written.
+	high, low chan writeReq
+	priority  *Priority
+	retry     RetryPolicy
+	quit      chan struct{}
 }
 
+// Close stops the client taking new commands, waits for the replies to the
+// ones already sent and closes the connection. Calling it again does nothing.
 func (mc *MPVClient) Close() error {
+	mc.i2cMtx.Lock()
+	if mc.closed {
+		mc.i2cMtx.Unlock()
+		return nil
+	}
+	mc.closed = true
+	mc.i2cMtx.Unlock()
+
+	// Anyone waiting to get a command written gives up now.
+	close(mc.quit)
+
 	// If we currently have outstanding return values for commands, we wait.
+	// Nothing can be added to wg once closed is set.
 	mc.wg.Wait()
-	return mc.nc.Close()
+
+	mc.i2cMtx.Lock()
+	defer mc.i2cMtx.Unlock()
+
+	if mc.idleTimer != nil {
+		mc.idleTimer.Stop()
+	}
+	return mc.disconnect()
+}
+
+// connect dials mpv if we aren't already connected. It must be called with
+// i2cMtx held.
+func (mc *MPVClient) connect() error {
+	if mc.nc != nil {
+		return nil
+	}
+
+	if mc.wasConnected {
+		mc.setState(Reconnecting)
+	}
+	nc, err := mc.dial()
+	if err != nil {
+		if mc.wasConnected {
+			mc.setState(Disconnected)
+		}
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			return fmt.Errorf("%w: %w: %w", ErrNotConnected, ErrTimeout, err)
+		}
+		return fmt.Errorf("%w: %w", ErrNotConnected, err)
+	}
+	mc.wasConnected = true
+	mc.setState(Connected)
+	mc.use(nc)
+
+	return nil
+}
+
+// use starts using nc as the connection to mpv. It must be called with
+// i2cMtx held.
+func (mc *MPVClient) use(nc net.Conn) {
+	mc.nc = nc
+	mc.rw = bufio.NewReadWriter(bufio.NewReader(nc), bufio.NewWriter(nc))
+	mc.monitorDone = make(chan struct{})
+	go mc.inputMonitor(mc.rw.Reader, mc.monitorDone)
+}
+
+// dropConnection disconnects and fails every command still waiting on the
+// connection with err. It must be called with i2cMtx held.
+func (mc *MPVClient) dropConnection(err error) {
+	mc.failPending(err)
+	if err := mc.disconnect(); err != nil {
+		log.Println(err)
+	}
+}
+
+// disconnect closes the connection, if there is one. It must be called with
+// i2cMtx held.
+func (mc *MPVClient) disconnect() error {
+	if mc.nc == nil {
+		return nil
+	}
+	err := mc.nc.Close()
+	mc.nc, mc.rw = nil, nil
+	mc.setState(Disconnected)
+	return err
+}
+
+// ConnState is the state of the connection to mpv.
+type ConnState int
+
+const (
+	Disconnected ConnState = iota
+	Reconnecting
+	Connected
+)
+
+func (cs ConnState) String() string {
+	switch cs {
+	case Disconnected:
+		return "disconnected"
+	case Reconnecting:
+		return "reconnecting"
+	case Connected:
+		return "connected"
+	}
+	return fmt.Sprintf("ConnState(%d)", int(cs))
+}
+
+// StateChanges returns a channel that gets every change in the connection
+// state from now on. A listener that falls behind misses changes rather than
+// holding up the client.
+func (mc *MPVClient) StateChanges() <-chan ConnState {
+	ch := make(chan ConnState, 8)
+
+	mc.statesMtx.Lock()
+	mc.states = append(mc.states, ch)
+	mc.statesMtx.Unlock()
+
+	return ch
+}
+
+func (mc *MPVClient) setState(cs ConnState) {
+	mc.statesMtx.Lock()
+	defer mc.statesMtx.Unlock()
+
+	for _, ch := range mc.states {
+		select {
+		case ch <- cs:
+		default:
+		}
+	}
+}
+
+// failPending answers every command still waiting for a reply with err, for
+// when the connection they were sent on is gone. It must be called with
+// i2cMtx held.
+func (mc *MPVClient) failPending(err error) {
+	reply, _ := json.Marshal(map[string]interface{}{"error": err.Error(), "not_connected": true})
+	for id, p := range mc.i2c {
+		delete(mc.i2c, id)
+		mc.finish(p, reply)
+	}
+}
+
+// SetPipe points the client at another mpv instance. Commands still waiting
+// on the old one are failed. Lazy clients dial the new pipe when next used,
+// the others straight away.
+func (mc *MPVClient) SetPipe(pipeName string) error {
+	mc.i2cMtx.Lock()
+	defer mc.i2cMtx.Unlock()
+
+	mc.dropConnection(errors.New("switched to another mpv instance"))
+	mc.dial = dialPipe(pipeName)
+
+	mc.oscMtx.Lock()
+	mc.oscMode = ""
+	mc.oscMtx.Unlock()
+
+	if mc.idleTimeout > 0 {
+		return nil
+	}
+	return mc.connect()
+}
+
+// idle is run by idleTimer. We only let go of the connection if nobody is
+// waiting on a reply, otherwise we give it another round.
+func (mc *MPVClient) idle() {
+	mc.i2cMtx.Lock()
+	defer mc.i2cMtx.Unlock()
+
+	if len(mc.i2c) > 0 {
+		mc.idleTimer.Reset(mc.idleTimeout)
+		return
+	}
+	if err := mc.disconnect(); err != nil {
+		log.Println(err)
+	}
+}
+
+// lost forgets the connection rd reads from if mpv went away under us, so the
+// next command dials again. The replies still owed on it are never coming, so
+// those commands fail. It is a no-op if we closed it ourselves.
+func (mc *MPVClient) lost(rd *bufio.Reader) {
+	mc.i2cMtx.Lock()
+	defer mc.i2cMtx.Unlock()
+
+	if mc.rw != nil && mc.rw.Reader == rd {
+		mc.dropConnection(errors.New("connection to mpv lost"))
+	}
+}
+
+// deliver hands a reply to whoever is waiting for msgID.
+func (mc *MPVClient) deliver(msgID uint32, reply []byte) {
+	mc.i2cMtx.Lock()
+	defer mc.i2cMtx.Unlock()
+
+	p, ok := mc.i2c[msgID]
+	if !ok {
+		mc.cmdLog.record("recv", reply, "")
+		log.Printf("Dropping reply to a request_id we haven't sent: %s", string(reply))
+		return
+	}
+	mc.cmdLog.record("recv", reply, p.trace)
+	if p.trace != "" {
+		log.Printf("[%s] request_id %d: reply %s", p.trace, msgID, bytes.TrimSpace(reply))
+	}
+	delete(mc.i2c, msgID)
+	mc.finish(p, reply)
+}
+
+// lineTooLongError is returned by readLine for lines over the limit. We keep
+// the end of the line, as that is where mpv puts the request_id.
+type lineTooLongError struct {
+	size int
+	tail []byte
+}
+
+func (e *lineTooLongError) Error() string { return fmt.Sprintf("line of %d bytes is too long", e.size) }
+
+var requestIDRe = regexp.MustCompile(`"request_id"\s*:\s*([-+.eE0-9]+)`)
+
+func (e *lineTooLongError) requestID() (uint32, bool) {
+	m := requestIDRe.FindSubmatch(e.tail)
+	if m == nil {
+		return 0, false
+	}
+	id, err := parseRequestID(m[1])
+	return id, err == nil
+}
+
+// parseRequestID reads the request_id of a reply. We always send whole
+// numbers, but it is a JSON number like any other, so we also take forms like
+// 7.0 or 7e0 and round them.
+func parseRequestID(raw []byte) (uint32, error) {
+	if id, err := strconv.ParseUint(string(raw), 10, 32); err == nil {
+		return uint32(id), nil
+	}
+	f, err := strconv.ParseFloat(string(raw), 64)
+	if err != nil {
+		return 0, err
+	}
+	f = math.Round(f)
+	if math.IsNaN(f) || f < 0 || f > math.MaxUint32 {
+		return 0, fmt.Errorf("request_id %s is out of range", raw)
+	}
+	return uint32(f), nil
+}
+
+// readLine is ReadBytes('\n'), except that it won't buffer more than max
+// bytes. Longer lines are read to the end and thrown away. A max of 0 means no
+// limit.
+func readLine(rd *bufio.Reader, max int) ([]byte, error) {
+	if max <= 0 {
+		return rd.ReadBytes('\n')
+	}
+
+	var line, tail []byte
+	size := 0
+	for {
+		frag, err := rd.ReadSlice('\n')
+		size += len(frag)
+
+		if size <= max {
+			line = append(line, frag...)
+		} else {
+			line = nil
+			tail = append(tail, frag...)
+			if len(tail) > 128 {
+				tail = tail[len(tail)-128:]
+			}
+		}
+
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return line, err
+		}
+		if size > max {
+			return nil, &lineTooLongError{size: size, tail: tail}
+		}
+		return line, nil
+	}
 }
 
-func (mc *MPVClient) inputMonitor() {
+func (mc *MPVClient) inputMonitor(rd *bufio.Reader, done chan struct{}) {
 	for {
-		dbt, err := mc.rw.ReadBytes('\n')
+		max := int(mc.maxLine.Load())
+		dbt, err := readLine(rd, max)
+		if tl, ok := err.(*lineTooLongError); ok {
+			log.Printf("Skipping a %d byte line, more than the %d we allow", tl.size, max)
+			if id, ok := tl.requestID(); ok {
+				mc.deliver(id, []byte(`{"error": "reply too large"}`))
+			}
+			continue
+		}
 		if err != nil {
-			if err != io.EOF {
+			if err != io.EOF && !errors.Is(err, net.ErrClosed) {
 				log.Println(err.Error())
 			}
+			close(done)
+			mc.lost(rd)
 			break
 		}
 
@@ -74,168 +447,2597 @@ func (mc *MPVClient) inputMonitor() {
 
 		// Is this a command event?
 		if err == jsonparser.KeyPathNotFoundError {
-			mc.i2cMtx.Lock()
-
-			// Get msg id.
-			msgID, err := jsonparser.GetInt(dbt, "request_id")
+			// Get msg id. Without one we can't know who is waiting for
+			// this, so all we can do is drop it.
+			raw, typ, _, err := jsonparser.Get(dbt, "request_id")
+			if err == nil && typ != jsonparser.Number {
+				err = errors.New("request_id is not a number")
+			}
+			var msgID uint32
+			if err == nil {
+				msgID, err = parseRequestID(raw)
+			}
 			if err != nil {
-				log.Fatal(err)
+				log.Printf("Dropping reply without a usable request_id ( %v ): %s", err, string(dbt))
+				continue
 			}
 
-			ch, ok := mc.i2c[uint32(msgID)]
-			if !ok {
-				log.Fatal("We haven't seen this ID before!")
+			mc.deliver(msgID, dbt)
+		} else {
+			// mpv's own log would flood ours.
+			if ename == "log-message" {
+				mc.publishLog(dbt)
+				continue
 			}
-			go func(msg []byte) {
-				ch <- msg
-				close(ch)
-			}(dbt)
+			log.Printf("We got event ( %s ): %s", ename, string(dbt))
+			mc.publish(dbt)
+		}
+	}
+}
 
-			mc.i2cMtx.Unlock()
-			mc.wg.Done()
+// Subscribe returns a channel that gets every event mpv sends, except log
+// messages, and a function to stop listening. A subscriber that can't keep up
+// misses events rather than holding up the monitor.
+func (mc *MPVClient) Subscribe() (<-chan []byte, func()) { return mc.subscribe(mc.subs) }
 
-		} else {
-			log.Printf("We got event ( %s ): %s", ename, string(dbt))
+func (mc *MPVClient) subscribe(subs map[chan []byte]struct{}) (<-chan []byte, func()) {
+	ch := make(chan []byte, 16)
+
+	mc.subsMtx.Lock()
+	subs[ch] = struct{}{}
+	mc.subsMtx.Unlock()
+
+	return ch, func() {
+		mc.subsMtx.Lock()
+		delete(subs, ch)
+		mc.subsMtx.Unlock()
+	}
+}
+
+// OnEvent calls f with every event called name, until the returned function
+// is called or the client is closed. f is called from a single goroutine, one
+// event at a time.
+func (mc *MPVClient) OnEvent(name string, f func(event []byte)) func() {
+	ch, unsubscribe := mc.Subscribe()
+	stop := make(chan struct{})
+	go func() {
+		defer unsubscribe()
+		for {
+			select {
+			case event := <-ch:
+				if ename, _ := jsonparser.GetString(event, "event"); ename == name {
+					f(event)
+				}
+			case <-stop:
+				return
+			case <-mc.quit:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() { once.Do(func() { close(stop) }) }
+}
+
+// WaitForEvent blocks until mpv sends an event called name, and returns it.
+// Only events sent after it is called count.
+func (mc *MPVClient) WaitForEvent(ctx context.Context, name string) ([]byte, error) {
+	wait, stop := mc.expectEvent(name)
+	defer stop()
+	return wait(ctx)
+}
+
+// expectEvent starts listening for events called one of names straight away,
+// but only waits for them when wait is called, which returns the next one.
+// That way an event that comes right after a command, like "file-loaded"
+// after loadfile, can't be missed. stop must be called when done.
+func (mc *MPVClient) expectEvent(names ...string) (wait func(context.Context) ([]byte, error), stop func()) {
+	ch, stop := mc.Subscribe()
+	return func(ctx context.Context) ([]byte, error) {
+		for {
+			select {
+			case event := <-ch:
+				ename, _ := jsonparser.GetString(event, "event")
+				for _, name := range names {
+					if ename == name {
+						return event, nil
+					}
+				}
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-mc.quit:
+				return nil, ErrClosed
+			}
+		}
+	}, stop
+}
+
+func (mc *MPVClient) publish(event []byte) {
+	mc.subsMtx.Lock()
+	defer mc.subsMtx.Unlock()
+
+	mc.history.add(event)
+
+	broadcast(mc.subs, event)
+	mc.deliverObserved(event)
+}
+
+// publishLog hands a log message to those subscribed with SubscribeLogs.
+func (mc *MPVClient) publishLog(event []byte) {
+	mc.subsMtx.Lock()
+	defer mc.subsMtx.Unlock()
+
+	broadcast(mc.logSubs, event)
+}
+
+// broadcast sends event to every channel in subs that has room for it. It
+// must be called with subsMtx held.
+func broadcast(subs map[chan []byte]struct{}, event []byte) {
+	for ch := range subs {
+		select {
+		case ch <- event:
+		default:
 		}
 	}
 }
 
-// Helper function to avoid code repetition.
-func (mc *MPVClient) sendCommand(cmd []byte) (<-chan []byte, error) {
-	// BUG(rhermes): There could be a problem here if the error happens,
-	// but if I Put the wg.Add after any of these, there could be race conditions.
+// ErrBadCommand is returned for a command that wouldn't be valid JSON once
+// the request_id is appended, so it never reaches mpv.
+var ErrBadCommand = errors.New("malformed command")
 
-	mc.i2cMtx.Lock()
-	defer mc.i2cMtx.Unlock()
+// checkCommand makes sure cmd is an object left open for the request_id, as
+// the JPC_ variables and buildCommand produce, and fits on a single line.
+func checkCommand(cmd []byte) error {
+	if bytes.ContainsAny(cmd, "\r\n") {
+		return fmt.Errorf("%w: %q spans more than one line", ErrBadCommand, cmd)
+	}
+	if !json.Valid(append(cmd[:len(cmd):len(cmd)], `, "request_id": 0}`...)) {
+		return fmt.Errorf("%w: %q must be an object left open for the request_id", ErrBadCommand, cmd)
+	}
+	return nil
+}
 
-	msgID := mc.rd.Uint32()
-	ncmd := []byte(fmt.Sprintf("%s, \"request_id\": %d}\n", cmd, msgID))
-	if _, err := mc.rw.Write(ncmd); err != nil {
+// Helper function to avoid code repetition. Idempotent commands that fail to
+// be written are tried again, as the retry policy allows.
+func (mc *MPVClient) sendCommand(cmd []byte) (<-chan []byte, error) {
+	if err := checkCommand(cmd); err != nil {
 		return nil, err
 	}
-	if err := mc.rw.Flush(); err != nil {
-		return nil, err
+	for attempt := 1; ; attempt++ {
+		ch, err := mc.sendOnce(cmd)
+		if err == nil || !mc.retry.retryable(cmd, err, attempt) {
+			return ch, err
+		}
+		log.Printf("Retrying after attempt %d failed: %v", attempt, err)
+		time.Sleep(mc.retry.backoff(attempt))
+	}
+}
+
+// sendOnce hands the command to the writer goroutine, and waits for it to
+// have been written.
+func (mc *MPVClient) sendOnce(cmd []byte) (<-chan []byte, error) {
+	req := writeReq{cmd: cmd, trace: mc.trace, res: make(chan writeRes, 1)}
+
+	q := mc.low
+	if mc.priority.high(cmd) {
+		q = mc.high
 	}
 
-	// Make the one off channel
-	mc.i2c[msgID] = make(chan []byte)
-	mc.wg.Add(1)
+	select {
+	case q <- req:
+	case <-mc.quit:
+		return nil, ErrClosed
+	}
 
-	return mc.i2c[msgID], nil
+	select {
+	case res := <-req.res:
+		return res.ch, res.err
+	case <-mc.quit:
+		return nil, ErrClosed
+	}
 }
 
-// Export the commands we need.
-func (mc *MPVClient) PauseToggle() (<-chan []byte, error) { return mc.sendCommand(JPC_PAUSE_TOGGLE_) }
+// write puts a single command on the wire. Only the writer goroutine calls it.
+//
+// The id is reserved in i2c before the write, so a reply that comes back
+// before we are done here still finds its channel. The lock is not held while
+// writing, as inputMonitor needs it to hand out replies, and mpv won't read
+// more from us while its replies aren't read.
+//
+// The waitgroup invariant: wg is only incremented once the command has been
+// fully written and flushed to mpv, and only if the reply hasn't already come
+// back and the client isn't closed. A failed write or flush drops the
+// reservation without touching wg, so Close never waits on a reply that can't
+// arrive.
+func (mc *MPVClient) write(cmd []byte, trace string) (<-chan []byte, error) {
+	mc.i2cMtx.Lock()
 
-func (mc *MPVClient) OSCOff() (<-chan []byte, error) { return mc.sendCommand(JPC_OSC_OFF) }
-func (mc *MPVClient) OSCOn() (<-chan []byte, error)  { return mc.sendCommand(JPC_OSC_ON) }
+	if mc.closed {
+		mc.i2cMtx.Unlock()
+		return nil, ErrClosed
+	}
+	if mc.maxInflight > 0 && len(mc.i2c) >= mc.maxInflight {
+		mc.i2cMtx.Unlock()
+		return nil, ErrBusy
+	}
+	// The monitor may have stopped without lost having got the lock yet.
+	// Don't write into a connection nobody reads replies from.
+	if mc.nc != nil {
+		select {
+		case <-mc.monitorDone:
+			mc.dropConnection(errors.New("connection to mpv lost"))
+		default:
+		}
+	}
+	if err := mc.connect(); err != nil {
+		mc.i2cMtx.Unlock()
+		return nil, err
+	}
+	if mc.idleTimeout > 0 {
+		if mc.idleTimer == nil {
+			mc.idleTimer = time.AfterFunc(mc.idleTimeout, mc.idle)
+		} else {
+			mc.idleTimer.Reset(mc.idleTimeout)
+		}
+	}
 
-func (mc *MPVClient) PlaylistPrev() (<-chan []byte, error) { return mc.sendCommand(JPC_PLAYLIST_PREV) }
-func (mc *MPVClient) PlaylistNext() (<-chan []byte, error) { return mc.sendCommand(JPC_PLAYLIST_NEXT) }
+	// An id still waiting for its reply can't be handed out again, or the
+	// two replies would end up in the same channel.
+	msgID := mc.rd.Uint32()
+	for _, taken := mc.i2c[msgID]; taken; _, taken = mc.i2c[msgID] {
+		msgID = mc.rd.Uint32()
+	}
+	p := mc.reserve(msgID, cmd, trace)
+	rw := mc.rw
 
-func (mc *MPVClient) ChapterPrev() (<-chan []byte, error) { return mc.sendCommand(JPC_CHAPTER_PREV) }
-func (mc *MPVClient) ChapterNext() (<-chan []byte, error) { return mc.sendCommand(JPC_CHAPTER_NEXT) }
+	mc.i2cMtx.Unlock()
 
-func (mc *MPVClient) PressLeft() (<-chan []byte, error)  { return mc.sendCommand(JPC_PRESS_LEFT) }
-func (mc *MPVClient) PressRight() (<-chan []byte, error) { return mc.sendCommand(JPC_PRESS_RIGHT) }
+	ncmd := []byte(fmt.Sprintf("%s, \"request_id\": %d}\n", cmd, msgID))
+	n, err := rw.Write(ncmd)
+	if err == nil && n < len(ncmd) {
+		err = io.ErrShortWrite
+	}
+	if err == nil {
+		err = rw.Flush()
+	}
+	if err == nil {
+		mc.cmdLog.record("send", ncmd, trace)
+		if trace != "" {
+			log.Printf("[%s] request_id %d: sent %s", trace, msgID, bytes.TrimSpace(ncmd))
+		}
+	}
 
-func NewMPVClient(pipeName string) (*MPVClient, error) {
-	var mc MPVClient
+	mc.i2cMtx.Lock()
+	defer mc.i2cMtx.Unlock()
 
-	nc, err := winio.DialPipe(pipeName, nil)
-	if err != nil {
+	switch {
+	case err != nil:
+		mc.unreserve(msgID, p)
+		// Part of the command may already be on the wire, and the
+		// writer keeps the rest buffered, so the next command would
+		// be garbled too. Start over on a new connection instead,
+		// which also means the replies still owed on this one never
+		// come.
+		if mc.rw == rw {
+			mc.dropConnection(fmt.Errorf("connection dropped after a failed write: %w", err))
+		}
 		return nil, err
+	case p.done:
+		// The reply is already waiting in the channel.
+	case mc.closed:
+		mc.unreserve(msgID, p)
+		return nil, ErrClosed
+	default:
+		p.counted = true
+		mc.wg.Add(1)
+		if d := time.Duration(mc.replyTimeout.Load()); d > 0 {
+			time.AfterFunc(d, func() { mc.expire(msgID, p) })
+		}
 	}
-	mc.nc = nc
-	mc.rw = bufio.NewReadWriter(bufio.NewReader(nc), bufio.NewWriter(nc))
-	mc.rd = rand.New(rand.NewSource(0))
-	mc.i2c = make(map[uint32](chan []byte))
+	return p.ch, nil
+}
 
-	go mc.inputMonitor()
+// expire gives up on the reply to msgID, if it still hasn't come. Should it
+// come after all, it is dropped.
+func (mc *MPVClient) expire(msgID uint32, p *pending) {
+	mc.i2cMtx.Lock()
+	defer mc.i2cMtx.Unlock()
 
-	return &mc, nil
+	if mc.i2c[msgID] != p {
+		return
+	}
+	delete(mc.i2c, msgID)
+	reply, _ := json.Marshal(map[string]interface{}{"error": "no reply from mpv", "timed_out": true})
+	mc.finish(p, reply)
 }
 
-func basicHandler(f func() (<-chan []byte, error)) func(http.ResponseWriter, *http.Request) {
-	return func(w http.ResponseWriter, r *http.Request) {
-		res, err := f()
-		if err != nil {
-			log.Println(err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		log.Println(string(<-res))
-		http.Redirect(w, r, "/", http.StatusFound)
+// reserve makes the one off reply channel for msgID. It must only be called
+// with i2cMtx held.
+func (mc *MPVClient) reserve(msgID uint32, cmd []byte, trace string) *pending {
+	p := &pending{ch: make(chan []byte, 1), cmd: cmd, trace: trace, sent: time.Now()}
+	mc.i2c[msgID] = p
+	return p
+}
+
+// unreserve drops a reservation for a command that never made it to mpv. It
+// must only be called with i2cMtx held.
+func (mc *MPVClient) unreserve(msgID uint32, p *pending) {
+	if mc.i2c[msgID] == p {
+		delete(mc.i2c, msgID)
 	}
 }
 
-func main() {
-	// Setup logger
-	log.SetFlags(log.Flags() | log.Llongfile)
+// finish hands p its reply. It must only be called with i2cMtx held, and
+// after p is out of i2c.
+func (mc *MPVClient) finish(p *pending, reply []byte) {
+	// The channel has room for exactly this reply, so this never blocks,
+	// and every reply lands in the channel for its own id no matter what
+	// order they arrive in.
+	p.ch <- annotate(reply, p.cmd, time.Since(p.sent))
+	close(p.ch)
+	p.done = true
+	if p.counted {
+		mc.wg.Done()
+	}
+}
+
+// pending is a command waiting for its reply.
+type pending struct {
+	ch    chan []byte
+	cmd   []byte
+	trace string
+	sent  time.Time
+
+	// counted is set once the command is in wg, done once it is answered.
+	counted, done bool
+}
 
-	mc, err := NewMPVClient(`\\.\pipe\mpv_socket`)
+// annotate adds what we know about the command to mpv's reply: "command",
+// the command array we sent, and "took_ms", how long mpv took to answer.
+// Everything mpv sent is left as it was.
+func annotate(reply, cmd []byte, took time.Duration) []byte {
+	reply = bytes.TrimSpace(reply)
+	if len(reply) < 2 || reply[0] != '{' {
+		return reply
+	}
+
+	// The command is still open for the request_id, close it so it parses.
+	args, _, _, err := jsonparser.Get(append(cmd[:len(cmd):len(cmd)], '}'), "command")
 	if err != nil {
-		log.Fatal(err)
+		return reply
 	}
-	defer mc.Close()
 
-	r := chi.NewRouter()
+	sep := ","
+	if bytes.TrimSpace(reply[1:])[0] == '}' {
+		sep = ""
+	}
+	return []byte(fmt.Sprintf(`{"command": %s, "took_ms": %d%s%s`, args, took.Milliseconds(), sep, reply[1:]))
+}
 
-	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
-		fmt.Fprintf(w, `
-		<html>
-		<head>
-			<meta charset="utf-8">
-			<meta http-equiv="x-ua-compatible" content="ie=edge">
-			<meta name="viewport" content="width=device-width, initial-scale=1">
-		</head>
-		<body>
-			<h1>Controls</h1>
-			<ul>
-				<li><a href="/api/pauseToggle">pauseToggle</a></li>
-				
-				<li></li>
-				
-				<li><a href="/api/oscOff">oscOff</a></li>
-				<li><a href="/api/oscOn">oscOn</a></li>
-				
-				<li></li>
-				
-				<li><a href="/api/playlistPrev">playlistPrev</a></li>
-				<li><a href="/api/playlistNext">playlistNext</a></li>
-				<li></li>
-				
-				<li><a href="/api/chapterPrev">chapterPrev</a></li>
-				<li><a href="/api/chapterNext">chapterNext</a></li>
-				
-				<li></li>
-				
-				<li><a href="/api/pressLeft">pressLeft</a></li>
-				<li><a href="/api/pressRight">pressRight</a></li>
-			</ul>
-		</body>
-		</html>
-		`)
-	})
+// buildCommand does what the JPC_ variables do, for commands with arguments.
+// Like them, the result is left open so sendCommand can append a request_id.
+func buildCommand(args ...interface{}) ([]byte, error) {
+	b, err := json.Marshal(args)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(fmt.Sprintf(`{"command": %s`, b)), nil
+}
 
-	// Pause
-	r.Get("/api/pauseToggle", basicHandler(mc.PauseToggle))
+// Command sends any mpv command, given as the elements of its command array.
+func (mc *MPVClient) Command(args ...interface{}) (<-chan []byte, error) {
+	cmd, err := buildCommand(args...)
+	if err != nil {
+		return nil, err
+	}
+	return mc.sendCommand(cmd)
+}
 
-	// OSC
-	r.Get("/api/oscOff", basicHandler(mc.OSCOff))
-	r.Get("/api/oscOn", basicHandler(mc.OSCOn))
+// ErrNotConnected is returned when mpv can't be reached, or the connection
+// went away before the reply came. ErrTimeout is returned along with it when
+// that was because reaching mpv took too long, and on its own when mpv didn't
+// reply within the time set by SetReplyTimeout.
+var (
+	ErrNotConnected = errors.New("not connected to mpv")
+	ErrTimeout      = errors.New("timed out")
+)
 
-	// Playlist
-	r.Get("/api/playlistNext", basicHandler(mc.PlaylistNext))
-	r.Get("/api/playlistPrev", basicHandler(mc.PlaylistPrev))
+// ErrMPVError matches every MPVError with errors.Is.
+var ErrMPVError = errors.New("mpv error")
 
-	// Playlist
-	r.Get("/api/chapterNext", basicHandler(mc.ChapterNext))
-	r.Get("/api/chapterPrev", basicHandler(mc.ChapterPrev))
+// MPVError is mpv turning down a command, with the reason it gave, like
+// "property unavailable".
+type MPVError struct {
+	Message string
+}
 
-	// Keys
-	r.Get("/api/pressLeft", basicHandler(mc.PressLeft))
-	r.Get("/api/pressRight", basicHandler(mc.PressRight))
+func (e *MPVError) Error() string        { return e.Message }
+func (e *MPVError) Is(target error) bool { return target == ErrMPVError }
 
-	http.ListenAndServe("192.168.1.177:3333", r)
+// replyData checks the error field of a reply from mpv and returns the data
+// field, which is empty for commands that don't return anything. mpv saying
+// no is an *MPVError.
+func replyData(reply []byte) ([]byte, error) {
+	status, err := jsonparser.GetString(reply, "error")
+	if err != nil {
+		return nil, err
+	}
+	if status != "success" {
+		// Replies we made up ourselves when the connection went.
+		if lost, _ := jsonparser.GetBoolean(reply, "not_connected"); lost {
+			return nil, fmt.Errorf("%w: %s", ErrNotConnected, status)
+		}
+		if late, _ := jsonparser.GetBoolean(reply, "timed_out"); late {
+			return nil, fmt.Errorf("%w: %s", ErrTimeout, status)
+		}
+		return nil, &MPVError{Message: status}
+	}
+
+	data, dt, _, err := jsonparser.Get(reply, "data")
+	if err != nil && err != jsonparser.KeyPathNotFoundError {
+		return nil, err
+	}
+	// jsonparser hands us strings without their quotes, put them back so
+	// the data is always valid JSON.
+	if dt == jsonparser.String {
+		data = []byte(`"` + string(data) + `"`)
+	}
+	return data, nil
+}
+
+// GetProperty waits for mpv to answer and returns the raw JSON value of the
+// property.
+func (mc *MPVClient) GetProperty(name string) ([]byte, error) {
+	res, err := mc.Command("get_property", name)
+	if err != nil {
+		return nil, err
+	}
+	return replyData(<-res)
+}
+
+// SetProperty sets a property to any value that encodes to the JSON mpv
+// expects for it.
+func (mc *MPVClient) SetProperty(name string, value interface{}) (<-chan []byte, error) {
+	return mc.Command("set_property", name, value)
+}
+
+// ErrBadArgument is returned when a command is given an argument we know mpv
+// won't accept, before anything is sent.
+var ErrBadArgument = errors.New("bad argument")
+
+// setChoice sets a property that only takes one of a few string values.
+func (mc *MPVClient) setChoice(name, value string, choices ...string) (<-chan []byte, error) {
+	for _, c := range choices {
+		if value == c {
+			return mc.SetProperty(name, value)
+		}
+	}
+	return nil, fmt.Errorf("%w: %s must be one of %v, got %q", ErrBadArgument, name, choices, value)
+}
+
+// SetKeepOpen controls what happens at the end of the last file.
+func (mc *MPVClient) SetKeepOpen(mode string) (<-chan []byte, error) {
+	return mc.setChoice("keep-open", mode, "yes", "no", "always")
+}
+
+// SetIdle controls if mpv quits or waits when there is nothing left to play.
+func (mc *MPVClient) SetIdle(mode string) (<-chan []byte, error) {
+	return mc.setChoice("idle", mode, "yes", "no", "once")
+}
+
+// SetVideoSync sets how mpv keeps audio and video in step. The display-*
+// modes time playback to the display's refresh rate, which can help with
+// stutter.
+func (mc *MPVClient) SetVideoSync(mode string) (<-chan []byte, error) {
+	return mc.setChoice("video-sync", mode,
+		"audio", "display-resample", "display-resample-vdrop", "display-resample-desync",
+		"display-tempo", "display-adrop", "display-vdrop", "display-desync", "desync")
+}
+
+// SetGaplessAudio sets if files are played back to back without a gap when
+// their audio formats allow it.
+func (mc *MPVClient) SetGaplessAudio(mode string) (<-chan []byte, error) {
+	return mc.setChoice("gapless-audio", mode, "yes", "no", "weak")
+}
+
+// SetAudioBuffer sets how many seconds of audio mpv keeps buffered for the
+// audio output.
+func (mc *MPVClient) SetAudioBuffer(seconds float64) (<-chan []byte, error) {
+	if seconds < 0 || seconds > 10 {
+		return nil, fmt.Errorf("%w: audio-buffer must be between 0 and 10 seconds, got %v", ErrBadArgument, seconds)
+	}
+	return mc.SetProperty("audio-buffer", seconds)
+}
+
+// AddVolume changes the volume by delta percent.
+func (mc *MPVClient) AddVolume(delta float64) (<-chan []byte, error) {
+	return mc.Command("add", "volume", delta)
+}
+
+// Seek moves the playback position by delta seconds.
+func (mc *MPVClient) Seek(delta float64) (<-chan []byte, error) {
+	return mc.Command("seek", delta, "relative")
+}
+
+// ErrIdle is returned when a command only makes sense with a file loaded, and
+// mpv has nothing loaded.
+var ErrIdle = errors.New("nothing is playing")
+
+// unavailable is true if mpv said no because the property has no value right
+// now, which mostly means that nothing is playing.
+func unavailable(err error) bool {
+	var me *MPVError
+	return errors.As(err, &me) && me.Message == "property unavailable"
+}
+
+// replied is for commands that had to look at their reply before returning,
+// it hands the reply on just like sendCommand would.
+func replied(reply []byte) <-chan []byte {
+	ch := make(chan []byte, 1)
+	ch <- reply
+	close(ch)
+	return ch
+}
+
+// Scrub jumps to percent of the way through the file.
+func (mc *MPVClient) Scrub(percent float64) (<-chan []byte, error) {
+	if percent < 0 || percent > 100 {
+		return nil, fmt.Errorf("%w: percent must be between 0 and 100, got %v", ErrBadArgument, percent)
+	}
+	res, err := mc.SetProperty("percent-pos", percent)
+	if err != nil {
+		return nil, err
+	}
+
+	// mpv can't seek when there is no file, which it reports as the
+	// property being unavailable.
+	reply := <-res
+	if _, err := replyData(reply); unavailable(err) {
+		return nil, ErrIdle
+	}
+	return replied(reply), nil
+}
+
+// SeekStart goes back to the start of the file, SeekEnd to its end.
+func (mc *MPVClient) SeekStart() (<-chan []byte, error) { return mc.Command("seek", 0, "absolute") }
+func (mc *MPVClient) SeekEnd() (<-chan []byte, error)   { return mc.Scrub(100) }
+
+// CycleValues moves property on to the next of values, wrapping around.
+func (mc *MPVClient) CycleValues(property string, values ...string) (<-chan []byte, error) {
+	if len(values) < 2 {
+		return nil, fmt.Errorf("%w: cycle-values needs at least two values", ErrBadArgument)
+	}
+	args := []interface{}{"cycle-values", property}
+	for _, v := range values {
+		args = append(args, v)
+	}
+	return mc.Command(args...)
+}
+
+// aspectRatios are the ones AspectCycle goes through, "-1" being whatever the
+// file says.
+var aspectRatios = []string{"-1", "16:9", "4:3", "2.35:1"}
+
+var aspectRe = regexp.MustCompile(`^(-1|\d+(\.\d+)?(:\d+(\.\d+)?)?)$`)
+
+// SetAspect overrides the aspect ratio, given as "16:9", "1.85" or "-1" to go
+// back to the native one.
+func (mc *MPVClient) SetAspect(ratio string) (<-chan []byte, error) {
+	if !aspectRe.MatchString(ratio) {
+		return nil, fmt.Errorf("%w: %q is not an aspect ratio", ErrBadArgument, ratio)
+	}
+	return mc.SetProperty("video-aspect-override", ratio)
+}
+
+func (mc *MPVClient) AspectCycle() (<-chan []byte, error) {
+	return mc.CycleValues("video-aspect-override", aspectRatios...)
+}
+
+// SetRotate turns the video by deg degrees clockwise, for clips filmed
+// sideways. Only quarter turns are allowed; -90 is the same as 270.
+func (mc *MPVClient) SetRotate(deg int) (<-chan []byte, error) {
+	if deg%90 != 0 {
+		return nil, fmt.Errorf("%w: rotation must be a multiple of 90, got %d", ErrBadArgument, deg)
+	}
+	return mc.SetProperty("video-rotate", (deg%360+360)%360)
+}
+
+func (mc *MPVClient) RotateCycle() (<-chan []byte, error) {
+	return mc.CycleValues("video-rotate", "0", "90", "180", "270")
+}
+
+// getString is GetProperty for string properties.
+func (mc *MPVClient) getString(name string) (string, error) {
+	data, err := mc.GetProperty(name)
+	if err != nil {
+		return "", err
+	}
+	var v string
+	err = json.Unmarshal(data, &v)
+	return v, err
+}
+
+// getFloat is GetProperty for numeric properties.
+func (mc *MPVClient) getFloat(name string) (float64, error) {
+	data, err := mc.GetProperty(name)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(string(data), 64)
+}
+
+// nudgeProperty adds delta to a numeric property, keeping the result within
+// min and max.
+func (mc *MPVClient) nudgeProperty(name string, delta, min, max float64) (<-chan []byte, error) {
+	cur, err := mc.getFloat(name)
+	if err != nil {
+		return nil, err
+	}
+	return mc.SetProperty(name, clamp(cur+delta, min, max))
+}
+
+func clamp(v, min, max float64) float64 {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
+
+// Subtitle styling. The ranges are the ones mpv accepts.
+func (mc *MPVClient) SetSubScale(scale float64) (<-chan []byte, error) {
+	return mc.SetProperty("sub-scale", clamp(scale, 0, 100))
+}
+func (mc *MPVClient) SetSubPos(pos float64) (<-chan []byte, error) {
+	return mc.SetProperty("sub-pos", clamp(pos, 0, 150))
+}
+func (mc *MPVClient) SetSubColor(color string) (<-chan []byte, error) {
+	return mc.SetProperty("sub-color", color)
+}
+func (mc *MPVClient) NudgeSubScale(delta float64) (<-chan []byte, error) {
+	return mc.nudgeProperty("sub-scale", delta, 0, 100)
+}
+func (mc *MPVClient) NudgeSubPos(delta float64) (<-chan []byte, error) {
+	return mc.nudgeProperty("sub-pos", delta, 0, 150)
+}
+
+// SetSubFont overrides the font for text subtitles, by family name as the
+// system knows it. It doesn't apply to styled ASS subtitles unless
+// sub-ass-override says so.
+func (mc *MPVClient) SetSubFont(name string) (<-chan []byte, error) {
+	if name == "" {
+		return nil, fmt.Errorf("%w: no font given", ErrBadArgument)
+	}
+	return mc.SetProperty("sub-font", name)
+}
+
+// SetSubFontSize sets the font size, scaled as if the window was 720 pixels
+// high.
+func (mc *MPVClient) SetSubFontSize(size float64) (<-chan []byte, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("%w: sub-font-size must be positive, got %v", ErrBadArgument, size)
+	}
+	return mc.SetProperty("sub-font-size", size)
+}
+
+// SubSeek seeks to the start of the subtitle line delta lines away, backwards
+// if it is negative. 0 goes back to the start of the current line.
+func (mc *MPVClient) SubSeek(delta int) (<-chan []byte, error) { return mc.Command("sub-seek", delta) }
+
+// SetSecondarySub shows a second subtitle track alongside the main one. id is
+// a track id, or "no" to turn it off.
+func (mc *MPVClient) SetSecondarySub(id string) (<-chan []byte, error) {
+	if id == "no" {
+		return mc.SetProperty("secondary-sid", id)
+	}
+	n, err := strconv.Atoi(id)
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("%w: secondary-sid must be a track id or \"no\", got %q", ErrBadArgument, id)
+	}
+	return mc.SetProperty("secondary-sid", n)
+}
+func (mc *MPVClient) ToggleSecondarySub() (<-chan []byte, error) {
+	return mc.Command("cycle", "secondary-sub-visibility")
+}
+
+// NowPlaying is the best name we can find for what is playing. It tries
+// "media-title" first, then falls back to "filename" and "path". If none of
+// them have a value it returns ErrIdle.
+func (mc *MPVClient) NowPlaying() (string, error) {
+	var lastErr error
+	idle := true
+	for _, name := range []string{"media-title", "filename", "path"} {
+		v, err := mc.getString(name)
+		if err != nil {
+			lastErr = err
+			idle = idle && unavailable(err)
+			continue
+		}
+		if v != "" {
+			return v, nil
+		}
+	}
+	if lastErr != nil && idle {
+		return "", ErrIdle
+	}
+	return "", lastErr
+}
+
+// ScreenshotToFile saves a screenshot to path on the mpv machine, which also
+// picks the image format by its extension. mode is "subtitles", "video" or
+// "window", as for mpv's screenshot command. An existing file is overwritten.
+func (mc *MPVClient) ScreenshotToFile(path, mode string) (<-chan []byte, error) {
+	if err := checkPath(path); err != nil {
+		return nil, err
+	}
+	switch mode {
+	case "subtitles", "video", "window":
+	default:
+		return nil, fmt.Errorf("%w: screenshot mode must be subtitles, video or window, got %q", ErrBadArgument, mode)
+	}
+	return mc.Command("screenshot-to-file", path, mode)
+}
+
+// checkPath turns away paths that can't be a file name on Windows, before mpv
+// gets to fail on them. A colon is only allowed after a drive letter.
+func checkPath(path string) error {
+	if strings.TrimSpace(path) == "" || strings.HasSuffix(path, `\`) || strings.HasSuffix(path, "/") {
+		return fmt.Errorf("%w: %q is not a file path", ErrBadArgument, path)
+	}
+	for i, c := range path {
+		if c < 32 || strings.ContainsRune(`<>"|?*`, c) || c == ':' && i != 1 {
+			return fmt.Errorf("%w: %q can't be in a file path, in %q", ErrBadArgument, c, path)
+		}
+	}
+	return nil
+}
+
+// Run has mpv start an external program, with args[0] as the program. It runs
+// as whatever user mpv runs as, so anyone who can reach this can run anything
+// on that machine. That is why the HTTP endpoint is off unless -allowRun is
+// given.
+func (mc *MPVClient) Run(args ...string) (<-chan []byte, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("%w: run needs a program", ErrBadArgument)
+	}
+	cmd := []interface{}{"run"}
+	for _, a := range args {
+		cmd = append(cmd, a)
+	}
+	return mc.Command(cmd...)
+}
+
+// LoadFile loads path, with mode being one of mpv's loadfile flags, like
+// "replace" or "append-play".
+func (mc *MPVClient) LoadFile(path, mode string) (<-chan []byte, error) {
+	return mc.Command("loadfile", path, mode)
+}
+
+// LoadFileTimeout is how long LoadFileThen waits for the file to load.
+var LoadFileTimeout = 30 * time.Second
+
+// LoadFileThen loads path in place of what is playing, and calls apply once
+// mpv has loaded it. Track and other per-file properties set before then are
+// lost when the file loads, so this is where to set them.
+func (mc *MPVClient) LoadFileThen(path string, apply func(*MPVClient) error) error {
+	wait, stop := mc.expectEvent("file-loaded", "end-file")
+	defer stop()
+
+	res, err := mc.LoadFile(path, "replace")
+	if err != nil {
+		return err
+	}
+	if _, err := replyData(<-res); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), LoadFileTimeout)
+	defer cancel()
+	for {
+		event, err := wait(ctx)
+		if err != nil {
+			return fmt.Errorf("waiting for %s to load: %w", path, err)
+		}
+		if ename, _ := jsonparser.GetString(event, "event"); ename == "file-loaded" {
+			return apply(mc)
+		}
+		// The file that was playing ends too, only our own failing
+		// to load matters.
+		if reason, _ := jsonparser.GetString(event, "reason"); reason == "error" {
+			msg, _ := jsonparser.GetString(event, "file_error")
+			return fmt.Errorf("%s failed to load: %s", path, msg)
+		}
+	}
+}
+
+// LoadFileWithOpts is LoadFile with per-file options, like "start" and "end",
+// that only apply to this file. Since mpv 0.38 the options come after an
+// insertion index, which is -1 here as it only matters for "insert-at".
+func (mc *MPVClient) LoadFileWithOpts(path, mode string, opts map[string]string) (<-chan []byte, error) {
+	if len(opts) == 0 {
+		return mc.LoadFile(path, mode)
+	}
+	index, err := mc.loadfileTakesIndex()
+	if err != nil {
+		return nil, err
+	}
+	if index {
+		return mc.Command("loadfile", path, mode, -1, formatOpts(opts))
+	}
+	return mc.Command("loadfile", path, mode, formatOpts(opts))
+}
+
+// formatOpts writes options the way loadfile wants them, "a=1,b=2". Values
+// that would confuse that get mpv's %length% quoting.
+func formatOpts(opts map[string]string) string {
+	keys := make([]string, 0, len(opts))
+	for k := range opts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		v := opts[k]
+		if strings.ContainsAny(v, `,=%"'[] `) {
+			v = fmt.Sprintf("%%%d%%%s", len(v), v)
+		}
+		parts = append(parts, k+"="+v)
+	}
+	return strings.Join(parts, ",")
+}
+
+// ReloadCurrent loads the current file again, starting where we were. This
+// gets a stalled stream going again.
+func (mc *MPVClient) ReloadCurrent() error {
+	path, err := mc.getString("path")
+	if unavailable(err) {
+		return ErrIdle
+	}
+	if err != nil {
+		return err
+	}
+
+	// Streams and files that haven't started yet have no position, so
+	// they start over.
+	pos, err := mc.getFloat("time-pos")
+	if err != nil && !unavailable(err) {
+		return err
+	}
+
+	res, err := mc.LoadFileWithOpts(path, "replace", map[string]string{"start": strconv.FormatFloat(pos, 'f', -1, 64)})
+	if err != nil {
+		return err
+	}
+	_, err = replyData(<-res)
+	return err
+}
+
+// Network stream settings. These apply to files loaded after they are set.
+func (mc *MPVClient) SetUserAgent(ua string) (<-chan []byte, error) {
+	return mc.SetProperty("user-agent", ua)
+}
+func (mc *MPVClient) SetReferrer(referrer string) (<-chan []byte, error) {
+	return mc.SetProperty("referrer", referrer)
+}
+
+// SetHTTPHeaders replaces the extra headers sent, each given as "Field: value".
+func (mc *MPVClient) SetHTTPHeaders(fields ...string) (<-chan []byte, error) {
+	if fields == nil {
+		fields = []string{}
+	}
+	return mc.SetProperty("http-header-fields", fields)
+}
+
+func (mc *MPVClient) GetDemuxerCacheState() (json.RawMessage, error) {
+	return mc.GetProperty("demuxer-cache-state")
+}
+
+// SeekableRange is a part of the stream that is cached, in seconds.
+type SeekableRange struct {
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+}
+
+// CacheState is the part of "demuxer-cache-state" needed to draw a buffer
+// indicator.
+type CacheState struct {
+	SeekableRanges []SeekableRange `json:"seekable-ranges"`
+	CacheDuration  float64         `json:"cache-duration"`
+}
+
+func (mc *MPVClient) CacheState() (*CacheState, error) {
+	raw, err := mc.GetDemuxerCacheState()
+	if err != nil {
+		return nil, err
+	}
+
+	var cs CacheState
+	if err := json.Unmarshal(raw, &cs); err != nil {
+		return nil, err
+	}
+	return &cs, nil
+}
+
+// CacheBufferingState is how full the cache is, in percent, while mpv is
+// paused waiting for it. It is unavailable for files that aren't streamed.
+func (mc *MPVClient) CacheBufferingState() (int, error) {
+	n, err := mc.getFloat("cache-buffering-state")
+	return int(n), err
+}
+
+// Loudness is the volume together with the replaygain mpv applies on top of
+// it. Gain is the file's replaygain tag for the mode in use, in dB, nil when
+// replaygain is off or the file has no tag for it.
+type Loudness struct {
+	Volume     float64  `json:"volume"`
+	ReplayGain string   `json:"replaygain"`
+	Preamp     float64  `json:"replaygain-preamp"`
+	Gain       *float64 `json:"gain"`
+}
+
+func (mc *MPVClient) Loudness() (*Loudness, error) {
+	var l Loudness
+	var err error
+	if l.Volume, err = mc.getFloat("volume"); err != nil {
+		return nil, err
+	}
+	if l.ReplayGain, err = mc.getString("replaygain"); err != nil {
+		return nil, err
+	}
+	if l.Preamp, err = mc.getFloat("replaygain-preamp"); err != nil {
+		return nil, err
+	}
+	if l.ReplayGain == "no" {
+		return &l, nil
+	}
+
+	data, err := mc.GetProperty("metadata")
+	if unavailable(err) {
+		return &l, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var tags map[string]string
+	if err := json.Unmarshal(data, &tags); err != nil {
+		return nil, err
+	}
+	// Tags are like "REPLAYGAIN_TRACK_GAIN": "-6.48 dB", in any case.
+	want := "replaygain_" + l.ReplayGain + "_gain"
+	for k, v := range tags {
+		if strings.ToLower(k) != want {
+			continue
+		}
+		gain, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(v), "dB")), 64)
+		if err == nil {
+			l.Gain = &gain
+		}
+		break
+	}
+	return &l, nil
+}
+
+// VideoInfo is the size and frame rate of the video being played. Fields are
+// nil when mpv doesn't know them, as for audio files, or for the estimated
+// rate while paused.
+type VideoInfo struct {
+	Width        *float64 `json:"width"`
+	Height       *float64 `json:"height"`
+	ContainerFPS *float64 `json:"container-fps"`
+	EstimatedFPS *float64 `json:"estimated-vf-fps"`
+}
+
+func (mc *MPVClient) VideoInfo() (*VideoInfo, error) {
+	var vi VideoInfo
+	for _, f := range []struct {
+		name string
+		v    **float64
+	}{
+		{"width", &vi.Width},
+		{"height", &vi.Height},
+		{"container-fps", &vi.ContainerFPS},
+		{"estimated-vf-fps", &vi.EstimatedFPS},
+	} {
+		n, err := mc.getFloat(f.name)
+		if unavailable(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		*f.v = &n
+	}
+	return &vi, nil
+}
+
+// Status is a snapshot of the properties the controls care about. Numbers
+// that mpv can't give us right now, like the duration when nothing is
+// playing, are left nil.
+type Status struct {
+	Pause         bool     `json:"pause"`
+	TimePos       *float64 `json:"time-pos"`
+	Duration      *float64 `json:"duration"`
+	TimeRemaining *float64 `json:"time-remaining"`
+	Volume        *float64 `json:"volume"`
+}
+
+func (mc *MPVClient) Status() (*Status, error) {
+	var st Status
+	props := []struct {
+		name string
+		v    interface{}
+	}{
+		{"pause", &st.Pause},
+		{"time-pos", &st.TimePos},
+		{"duration", &st.Duration},
+		{"time-remaining", &st.TimeRemaining},
+		{"volume", &st.Volume},
+	}
+
+	// Ask for a few at a time, which is quicker than one by one without
+	// flooding the writer.
+	sem := make(chan struct{}, mc.fanOut())
+	errs := make([]error, len(props))
+	var wg sync.WaitGroup
+	for i, p := range props {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, name string, v interface{}) {
+			defer func() { <-sem; wg.Done() }()
+
+			data, err := mc.GetProperty(name)
+			if unavailable(err) {
+				return
+			}
+			if err == nil {
+				err = json.Unmarshal(data, v)
+			}
+			if err != nil {
+				errs[i] = fmt.Errorf("%s: %w", name, err)
+			}
+		}(i, p.name, p.v)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &st, nil
+}
+
+// fanOut is how many properties aggregate calls like Status ask for at once.
+func (mc *MPVClient) fanOut() int {
+	if n := int(mc.statusConcurrency.Load()); n > 0 {
+		return n
+	}
+	return 4
+}
+
+// SetStatusConcurrency bounds how many properties aggregate calls like Status
+// ask for at once. 0 goes back to the default of 4.
+func (mc *MPVClient) SetStatusConcurrency(n int) { mc.statusConcurrency.Store(int64(n)) }
+
+// TimeRemaining is the number of seconds left of the file, at the current
+// speed.
+func (mc *MPVClient) TimeRemaining() (float64, error) { return mc.getFloat("time-remaining") }
+
+// Chapter is a single entry in mpv's "chapter-list" property.
+type Chapter struct {
+	Title string  `json:"title"`
+	Time  float64 `json:"time"`
+}
+
+func (mc *MPVClient) GetChapterList() ([]Chapter, error) {
+	data, err := mc.GetProperty("chapter-list")
+	if err != nil {
+		return nil, err
+	}
+
+	var chapters []Chapter
+	if err := json.Unmarshal(data, &chapters); err != nil {
+		return nil, err
+	}
+	return chapters, nil
+}
+
+// ErrNotFound is returned when what was asked for doesn't exist.
+var ErrNotFound = errors.New("not found")
+
+// SeekChapterByTitle jumps to the first chapter with substr in its title,
+// ignoring case.
+func (mc *MPVClient) SeekChapterByTitle(substr string) error {
+	chapters, err := mc.GetChapterList()
+	if err != nil {
+		return err
+	}
+
+	substr = strings.ToLower(substr)
+	for i, c := range chapters {
+		if !strings.Contains(strings.ToLower(c.Title), substr) {
+			continue
+		}
+		res, err := mc.SetProperty("chapter", i)
+		if err != nil {
+			return err
+		}
+		_, err = replyData(<-res)
+		return err
+	}
+	return fmt.Errorf("%w: no chapter title contains %q", ErrNotFound, substr)
+}
+
+// MultiplyProperty multiplies a numeric property by factor.
+func (mc *MPVClient) MultiplyProperty(name string, factor float64) (<-chan []byte, error) {
+	return mc.Command("multiply", name, factor)
+}
+
+// MultiplySpeed multiplies the playback speed by factor, but never takes it
+// outside min and max, so pressing speed up over and over stops at max.
+func (mc *MPVClient) MultiplySpeed(factor, min, max float64) (<-chan []byte, error) {
+	return mc.multiplySpeed(factor, min, max, false)
+}
+
+// MultiplySpeedOSD is MultiplySpeed with mpv showing the new speed on its OSD
+// bar.
+func (mc *MPVClient) MultiplySpeedOSD(factor, min, max float64) (<-chan []byte, error) {
+	return mc.multiplySpeed(factor, min, max, true)
+}
+
+func (mc *MPVClient) multiplySpeed(factor, min, max float64, osd bool) (<-chan []byte, error) {
+	if factor <= 0 {
+		return nil, fmt.Errorf("%w: factor must be positive, got %v", ErrBadArgument, factor)
+	}
+	cur, err := mc.getFloat("speed")
+	if err != nil {
+		return nil, err
+	}
+	if next := cur * factor; next < min || next > max {
+		next = clamp(next, min, max)
+		if osd {
+			return mc.Command("osd-bar", "set", "speed", strconv.FormatFloat(next, 'f', -1, 64))
+		}
+		return mc.SetProperty("speed", next)
+	}
+	if osd {
+		return mc.Command("osd-bar", "multiply", "speed", factor)
+	}
+	return mc.MultiplyProperty("speed", factor)
+}
+
+// Pan and zoom, for cropping away black bars. The ranges are the ones mpv
+// accepts.
+func (mc *MPVClient) SetZoom(zoom float64) (<-chan []byte, error) {
+	return mc.SetProperty("video-zoom", clamp(zoom, -20, 20))
+}
+func (mc *MPVClient) SetPanX(pan float64) (<-chan []byte, error) {
+	return mc.SetProperty("video-pan-x", clamp(pan, -3, 3))
+}
+func (mc *MPVClient) SetPanY(pan float64) (<-chan []byte, error) {
+	return mc.SetProperty("video-pan-y", clamp(pan, -3, 3))
+}
+func (mc *MPVClient) NudgeZoom(delta float64) (<-chan []byte, error) {
+	return mc.nudgeProperty("video-zoom", delta, -20, 20)
+}
+func (mc *MPVClient) NudgePanX(delta float64) (<-chan []byte, error) {
+	return mc.nudgeProperty("video-pan-x", delta, -3, 3)
+}
+func (mc *MPVClient) NudgePanY(delta float64) (<-chan []byte, error) {
+	return mc.nudgeProperty("video-pan-y", delta, -3, 3)
+}
+
+// SetLoopPlaylist sets how often the playlist is repeated: "no", "inf",
+// "force" or a number of times.
+func (mc *MPVClient) SetLoopPlaylist(mode string) (<-chan []byte, error) {
+	if n, err := strconv.Atoi(mode); err == nil {
+		if n < 1 {
+			return nil, fmt.Errorf("%w: loop-playlist count must be at least 1, got %d", ErrBadArgument, n)
+		}
+		return mc.SetProperty("loop-playlist", n)
+	}
+	return mc.setChoice("loop-playlist", mode, "no", "inf", "force")
+}
+
+// SetLoopFile sets how often the current file is repeated: "no", "inf" or a
+// number of times, 0 being the same as "no".
+func (mc *MPVClient) SetLoopFile(count string) (<-chan []byte, error) {
+	if n, err := strconv.Atoi(count); err == nil {
+		if n < 0 {
+			return nil, fmt.Errorf("%w: loop-file count can't be negative, got %d", ErrBadArgument, n)
+		}
+		return mc.SetProperty("loop-file", n)
+	}
+	return mc.setChoice("loop-file", count, "no", "inf")
+}
+
+// LoopCycle turns playlist looping off if it is on in any way, and on forever
+// if it is off. It returns the new mode.
+func (mc *MPVClient) LoopCycle() (string, error) {
+	data, err := mc.GetProperty("loop-playlist")
+	if err != nil {
+		return "", err
+	}
+
+	// mpv reports "no" as false.
+	mode := "inf"
+	if cur := string(data); cur != "false" && cur != `"no"` {
+		mode = "no"
+	}
+
+	res, err := mc.SetLoopPlaylist(mode)
+	if err != nil {
+		return "", err
+	}
+	if _, err := replyData(<-res); err != nil {
+		return "", err
+	}
+	return mode, nil
+}
+
+// ExpandText has mpv fill in the properties in template, like
+// "${time-pos}/${duration}", and returns the result.
+func (mc *MPVClient) ExpandText(template string) (string, error) {
+	res, err := mc.Command("expand-text", template)
+	if err != nil {
+		return "", err
+	}
+	data, err := replyData(<-res)
+	if err != nil {
+		return "", err
+	}
+	var text string
+	err = json.Unmarshal(data, &text)
+	return text, err
+}
+
+// PrintText has mpv expand template like ExpandText does, but print the result
+// to its own terminal and log. The reply only tells if that worked, the text
+// never comes back over IPC, so use ExpandText to read it.
+func (mc *MPVClient) PrintText(template string) (<-chan []byte, error) {
+	return mc.Command("print-text", template)
+}
+
+// GetHWDecCurrent is the hardware decoding API in use, "no" for software
+// decoding. It is unavailable when no video is playing.
+func (mc *MPVClient) GetHWDecCurrent() (string, error) { return mc.getString("hwdec-current") }
+
+// IsIdle is true when mpv has no file loaded.
+func (mc *MPVClient) IsIdle() (bool, error) {
+	data, err := mc.GetProperty("idle-active")
+	if err != nil {
+		return false, err
+	}
+	return string(data) == "true", nil
+}
+
+// Track is a single entry in mpv's "track-list" property.
+type Track struct {
+	ID       int    `json:"id"`
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Lang     string `json:"lang"`
+	Selected bool   `json:"selected"`
+	Default  bool   `json:"default"`
+}
+
+func (mc *MPVClient) GetTrackList() ([]Track, error) {
+	data, err := mc.GetProperty("track-list")
+	if err != nil {
+		return nil, err
+	}
+
+	var tracks []Track
+	if err := json.Unmarshal(data, &tracks); err != nil {
+		return nil, err
+	}
+	return tracks, nil
+}
+
+// Edition is a single entry in mpv's "edition-list" property, for files
+// with more than one cut of the same thing.
+type Edition struct {
+	ID      int    `json:"id"`
+	Title   string `json:"title"`
+	Default bool   `json:"default"`
+}
+
+func (mc *MPVClient) GetEditionList() ([]Edition, error) {
+	data, err := mc.GetProperty("edition-list")
+	if err != nil {
+		return nil, err
+	}
+
+	var editions []Edition
+	if err := json.Unmarshal(data, &editions); err != nil {
+		return nil, err
+	}
+	return editions, nil
+}
+
+func (mc *MPVClient) GetEdition() (int, error) {
+	n, err := mc.getFloat("edition")
+	return int(n), err
+}
+
+func (mc *MPVClient) SetEdition(index int) (<-chan []byte, error) {
+	if index < 0 {
+		return nil, fmt.Errorf("%w: edition must be 0 or more, got %d", ErrBadArgument, index)
+	}
+	return mc.SetProperty("edition", index)
+}
+
+// AudioDevice is a single entry in mpv's "audio-device-list" property.
+type AudioDevice struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+func (mc *MPVClient) GetAudioDevices() ([]AudioDevice, error) {
+	data, err := mc.GetProperty("audio-device-list")
+	if err != nil {
+		return nil, err
+	}
+
+	var devices []AudioDevice
+	if err := json.Unmarshal(data, &devices); err != nil {
+		return nil, err
+	}
+	return devices, nil
+}
+
+func (mc *MPVClient) SetAudioDevice(name string) (<-chan []byte, error) {
+	return mc.SetProperty("audio-device", name)
+}
+
+// AudioDeviceCycle switches to the audio device after the current one in
+// "audio-device-list", going back to the first after the last. It returns
+// the new device.
+func (mc *MPVClient) AudioDeviceCycle() (*AudioDevice, error) {
+	devices, err := mc.GetAudioDevices()
+	if err != nil {
+		return nil, err
+	}
+	if len(devices) == 0 {
+		return nil, fmt.Errorf("%w: mpv lists no audio devices", ErrNotFound)
+	}
+	cur, err := mc.getString("audio-device")
+	if err != nil {
+		return nil, err
+	}
+
+	// A current device that isn't listed starts us at the first.
+	next := 0
+	for i, d := range devices {
+		if d.Name == cur {
+			next = (i + 1) % len(devices)
+			break
+		}
+	}
+
+	res, err := mc.SetAudioDevice(devices[next].Name)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := replyData(<-res); err != nil {
+		return nil, err
+	}
+	return &devices[next], nil
+}
+
+// PlaylistEntry is a single entry in mpv's "playlist" property.
+type PlaylistEntry struct {
+	Filename string `json:"filename"`
+	Title    string `json:"title,omitempty"`
+	Current  bool   `json:"current,omitempty"`
+	Playing  bool   `json:"playing,omitempty"`
+}
+
+func (mc *MPVClient) GetPlaylist() ([]PlaylistEntry, error) {
+	data, err := mc.GetProperty("playlist")
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []PlaylistEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// Session is everything a freshly loaded page needs to draw itself, so it
+// can start with one request instead of many.
+type Session struct {
+	Title string `json:"title"`
+	*Status
+	Playlist []PlaylistEntry `json:"playlist"`
+}
+
+func (mc *MPVClient) Session() (*Session, error) {
+	st, err := mc.Status()
+	if err != nil {
+		return nil, err
+	}
+	sess := Session{Status: st}
+
+	// There is no title when nothing is playing.
+	if sess.Title, err = mc.NowPlaying(); err != nil && !errors.Is(err, ErrIdle) && !unavailable(err) {
+		return nil, err
+	}
+	if sess.Playlist, err = mc.GetPlaylist(); err != nil {
+		return nil, err
+	}
+	if sess.Playlist == nil {
+		sess.Playlist = []PlaylistEntry{}
+	}
+	return &sess, nil
+}
+
+// Export the commands we need.
+func (mc *MPVClient) PauseToggle() (<-chan []byte, error) { return mc.sendCommand(JPC_PAUSE_TOGGLE_) }
+func (mc *MPVClient) PauseOn() (<-chan []byte, error)     { return mc.sendCommand(JPC_PAUSE_ON) }
+func (mc *MPVClient) PauseOff() (<-chan []byte, error)    { return mc.sendCommand(JPC_PAUSE_OFF) }
+
+// Stop stops playback and clears the playlist.
+func (mc *MPVClient) Stop() (<-chan []byte, error) { return mc.Command("stop") }
+
+func (mc *MPVClient) SetPause(on bool) (<-chan []byte, error) {
+	if on {
+		return mc.PauseOn()
+	}
+	return mc.PauseOff()
+}
+
+// SafeQuit pauses, and once mpv has confirmed that, quits while saving the
+// position so playback can be resumed later.
+func (mc *MPVClient) SafeQuit() (<-chan []byte, error) {
+	res, err := mc.PauseOn()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := replyData(<-res); err != nil {
+		return nil, err
+	}
+	return mc.Command("quit-watch-later")
+}
+
+func (mc *MPVClient) OSCOff() (<-chan []byte, error) { return mc.oscCommand(JPC_OSC_OFF, "never") }
+func (mc *MPVClient) OSCOn() (<-chan []byte, error)  { return mc.oscCommand(JPC_OSC_ON, "always") }
+
+// oscModes are the osc-visibility values OSCCycle goes through, in order.
+var oscModes = []string{"never", "auto", "always"}
+
+// OSCCycle moves the OSC on to the next of oscModes and returns the one it
+// is in now. As the osc can't tell us its mode, the next one is worked out
+// from what we last set, or "auto", the osc's default, if we haven't yet.
+func (mc *MPVClient) OSCCycle() (string, error) {
+	mc.oscMtx.Lock()
+	cur := mc.oscMode
+	mc.oscMtx.Unlock()
+	if cur == "" {
+		cur = "auto"
+	}
+
+	next := oscModes[0]
+	for i, m := range oscModes {
+		if m == cur {
+			next = oscModes[(i+1)%len(oscModes)]
+		}
+	}
+
+	cmd, err := buildCommand("script-message", "osc-visibility", next)
+	if err != nil {
+		return "", err
+	}
+	res, err := mc.oscCommand(cmd, next)
+	if err != nil {
+		return "", err
+	}
+	if _, err := replyData(<-res); err != nil {
+		return "", err
+	}
+	return next, nil
+}
+
+// hideOSCLater turns the OSC off after delay, unless playback is paused by
+// then, like players that show their controls while paused.
+func (mc *MPVClient) hideOSCLater(delay time.Duration) {
+	time.AfterFunc(delay, func() {
+		data, err := mc.GetProperty("pause")
+		if err != nil || string(data) != "false" {
+			return
+		}
+		res, err := mc.OSCOff()
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		<-res
+	})
+}
+
+// ErrNoOSC is returned for OSC commands when the osc script isn't loaded, as
+// mpv happily accepts script messages nobody is listening for.
+var ErrNoOSC = errors.New("the osc script isn't loaded")
+
+// oscCommand only sends cmd if the osc script is there to get it. The "osc"
+// option is what decides if mpv loads it. mode is the osc-visibility cmd
+// sets, remembered for OSCCycle.
+func (mc *MPVClient) oscCommand(cmd []byte, mode string) (<-chan []byte, error) {
+	data, err := mc.GetProperty("osc")
+	if err != nil {
+		return nil, err
+	}
+	if string(data) != "true" {
+		return nil, ErrNoOSC
+	}
+	res, err := mc.sendCommand(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	mc.oscMtx.Lock()
+	mc.oscMode = mode
+	mc.oscMtx.Unlock()
+	return res, nil
+}
+
+func (mc *MPVClient) ToggleStats() (<-chan []byte, error) { return mc.sendCommand(JPC_STATS_TOGGLE) }
+
+func (mc *MPVClient) SetBorder(on bool) (<-chan []byte, error) { return mc.SetProperty("border", on) }
+func (mc *MPVClient) ToggleBorder() (<-chan []byte, error)     { return mc.Command("cycle", "border") }
+
+// SetTaskbarProgress shows playback progress on mpv's taskbar button. Only
+// Windows has it.
+func (mc *MPVClient) SetTaskbarProgress(on bool) (<-chan []byte, error) {
+	return mc.SetProperty("taskbar-progress", on)
+}
+
+// The window title is what the OS shows for the window, not media-title.
+func (mc *MPVClient) SetTitle(title string) (<-chan []byte, error) {
+	return mc.SetProperty("title", title)
+}
+func (mc *MPVClient) GetTitle() (string, error) { return mc.getString("title") }
+
+func (mc *MPVClient) PlaylistPrev() (<-chan []byte, error) { return mc.sendCommand(JPC_PLAYLIST_PREV) }
+func (mc *MPVClient) PlaylistNext() (<-chan []byte, error) { return mc.sendCommand(JPC_PLAYLIST_NEXT) }
+
+// PlaylistJump moves offset entries along the playlist, backwards if it is
+// negative. Going past either end stops at the first or last entry.
+func (mc *MPVClient) PlaylistJump(offset int) (<-chan []byte, error) {
+	count, err := mc.getFloat("playlist-count")
+	if err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return nil, fmt.Errorf("%w: the playlist is empty", ErrIdle)
+	}
+	// -1 when nothing in the playlist is playing.
+	pos, err := mc.getFloat("playlist-pos")
+	if err != nil {
+		return nil, err
+	}
+	return mc.SetProperty("playlist-pos", int(clamp(pos+float64(offset), 0, count-1)))
+}
+
+func (mc *MPVClient) ChapterPrev() (<-chan []byte, error) { return mc.AddChapter(-1) }
+func (mc *MPVClient) ChapterNext() (<-chan []byte, error) { return mc.AddChapter(1) }
+
+// AddChapter skips delta chapters, backwards if it is negative.
+func (mc *MPVClient) AddChapter(delta int) (<-chan []byte, error) {
+	return mc.Command("add", "chapter", delta)
+}
+
+func (mc *MPVClient) PressLeft() (<-chan []byte, error)  { return mc.sendCommand(JPC_PRESS_LEFT) }
+func (mc *MPVClient) PressRight() (<-chan []byte, error) { return mc.sendCommand(JPC_PRESS_RIGHT) }
+
+func newMPVClient(dial func() (net.Conn, error)) *MPVClient {
+	mc := &MPVClient{clientState: &clientState{}}
+
+	mc.dial = dial
+	mc.rd = rand.New(rand.NewSource(0))
+	mc.i2c = make(map[uint32]*pending)
+	mc.subs = make(map[chan []byte]struct{})
+	mc.logSubs = make(map[chan []byte]struct{})
+	mc.observed = make(map[int64]chan []byte)
+	mc.history = newEventLog(100)
+	mc.high = make(chan writeReq, writeQueueLen)
+	mc.low = make(chan writeReq, writeQueueLen)
+	mc.priority = DefaultPriority
+	mc.quit = make(chan struct{})
+
+	go mc.writer()
+
+	return mc
+}
+
+// SetMaxLineSize limits how long a line from mpv may be before it is skipped
+// instead of read into memory. A command whose reply is skipped gets an error
+// reply instead.
+func (mc *MPVClient) SetMaxLineSize(n int) { mc.maxLine.Store(int64(n)) }
+
+// SetReplyTimeout limits how long a command waits for mpv to reply. One that
+// waits longer gets an error reply instead, which replyData turns into
+// ErrTimeout. 0 means no limit.
+func (mc *MPVClient) SetReplyTimeout(d time.Duration) { mc.replyTimeout.Store(int64(d)) }
+
+// ErrBusy is returned when the limit set by SetMaxInflight is reached.
+var ErrBusy = errors.New("too many commands waiting for mpv")
+
+// SetMaxInflight limits how many commands can wait for a reply at once.
+// Commands over the limit fail with ErrBusy. 0 means no limit.
+func (mc *MPVClient) SetMaxInflight(n int) {
+	mc.i2cMtx.Lock()
+	defer mc.i2cMtx.Unlock()
+
+	mc.maxInflight = n
+}
+
+// SetRetryPolicy changes how commands that fail to be written are retried. It
+// must be called before the client is used.
+func (mc *MPVClient) SetRetryPolicy(rp RetryPolicy) { mc.retry = rp }
+
+// SetPriority changes how commands are sorted into the writer's queues.
+func (mc *MPVClient) SetPriority(p *Priority) { mc.priority = p }
+
+func dialPipe(pipeName string) func() (net.Conn, error) {
+	return func() (net.Conn, error) { return winio.DialPipe(pipeName, nil) }
+}
+
+func NewMPVClient(pipeName string) (*MPVClient, error) {
+	mc := newMPVClient(dialPipe(pipeName))
+
+	mc.i2cMtx.Lock()
+	defer mc.i2cMtx.Unlock()
+
+	if err := mc.connect(); err != nil {
+		return nil, err
+	}
+
+	return mc, nil
+}
+
+// ErrNoRedial is returned by clients made with NewMPVClientConn once their
+// connection is gone, as there is no way for us to get a new one.
+var ErrNoRedial = errors.New("connection is gone and can't be redialed")
+
+// NewMPVClientConn wraps a connection that has already been made, for
+// example one end of a net.Pipe in tests.
+func NewMPVClientConn(conn net.Conn) *MPVClient {
+	mc := newMPVClient(func() (net.Conn, error) { return nil, ErrNoRedial })
+
+	mc.wasConnected = true
+	mc.use(conn)
+
+	return mc
+}
+
+// NewLazyMPVClient doesn't connect until the first command is sent, and lets
+// go of the connection again once it has gone idleTimeout without use. The
+// next command after that reconnects.
+func NewLazyMPVClient(pipeName string, idleTimeout time.Duration) *MPVClient {
+	mc := newMPVClient(dialPipe(pipeName))
+	mc.idleTimeout = idleTimeout
+	return mc
+}
+
+// httpError logs err and reports it to the client, as a bad request if the
+// caller gave us an argument we won't forward.
+func httpError(w http.ResponseWriter, r *http.Request, err error) {
+	tracef(r, "%v", err)
+	code := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, ErrBadArgument):
+		code = http.StatusBadRequest
+	case errors.Is(err, ErrIdle), errors.Is(err, ErrNoOSC):
+		code = http.StatusConflict
+	case errors.Is(err, ErrNotFound):
+		code = http.StatusNotFound
+	case errors.Is(err, ErrBusy):
+		code = http.StatusServiceUnavailable
+	case errors.Is(err, ErrTimeout):
+		code = http.StatusGatewayTimeout
+	case errors.Is(err, ErrNotConnected):
+		code = http.StatusServiceUnavailable
+	case errors.Is(err, ErrMPVError):
+		code = http.StatusBadGateway
+	}
+	if r.Context().Value(jsonRepliesKey{}) != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+	http.Error(w, err.Error(), code)
+}
+
+// basicHandler has f send a command with the client for the request, see
+// requestClient, and finishes off the request once the reply is in.
+func basicHandler(f func(*MPVClient) (<-chan []byte, error)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tracef(r, "sending command for %s", r.URL)
+		res, err := f(requestClient(r))
+		if err != nil {
+			httpError(w, r, err)
+			return
+		}
+		done(w, r, <-res)
+	}
+}
+
+// Envelope is how the JSON API hands back a reply from mpv, together with the
+// command it answers. Error is nil when mpv reported success.
+type Envelope struct {
+	Command json.RawMessage `json:"command"`
+	Result  json.RawMessage `json:"result"`
+	Error   *string         `json:"error"`
+	TookMS  int64           `json:"took_ms"`
+}
+
+func newEnvelope(reply []byte) Envelope {
+	var env Envelope
+	var raw struct {
+		Command json.RawMessage `json:"command"`
+		Data    json.RawMessage `json:"data"`
+		Error   string          `json:"error"`
+		TookMS  int64           `json:"took_ms"`
+	}
+	if err := json.Unmarshal(reply, &raw); err != nil {
+		msg := err.Error()
+		env.Error = &msg
+		return env
+	}
+
+	env.Command, env.Result, env.TookMS = raw.Command, raw.Data, raw.TookMS
+	if raw.Error != "success" {
+		env.Error = &raw.Error
+	}
+	return env
+}
+
+// playing wraps the handler of a playback control, so that it answers with
+// ErrIdle instead of sending a command that does nothing when no file is
+// loaded.
+func playing(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		idle, err := requestClient(r).IsIdle()
+		if err != nil {
+			httpError(w, r, err)
+			return
+		}
+		if idle {
+			httpError(w, r, ErrIdle)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// apiOnly is set when there is no HTML page to send people back to.
+var apiOnly bool
+
+type jsonRepliesKey struct{}
+
+// jsonReplies has the routes it is used on answer with JSON, as if -apiOnly
+// was given.
+func jsonReplies(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), jsonRepliesKey{}, true)))
+	})
+}
+
+// wantsJSON is true if r should get the reply, not a redirect.
+func wantsJSON(r *http.Request) bool {
+	return apiOnly || r.Context().Value(jsonRepliesKey{}) != nil
+}
+
+// done finishes off a request once the reply is in. Browsers are sent back to
+// where they came from, see redirectBack, API clients get the reply itself.
+func done(w http.ResponseWriter, r *http.Request, reply []byte) {
+	tracef(r, "reply: %s", reply)
+	if wantsJSON(r) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(newEnvelope(reply))
+		return
+	}
+	redirectBack(w, r)
+}
+
+// redirectBack sends the browser on after a command. That is ?redirect= if
+// given, else the page the command came from, else the root page. Only paths
+// on this server are followed, so we can't be used to send people elsewhere.
+func redirectBack(w http.ResponseWriter, r *http.Request) {
+	target := "/"
+	if to := r.FormValue("redirect"); localPath(to) {
+		target = to
+	} else if ref, err := url.Parse(r.Referer()); err == nil && ref.Host == r.Host && localPath(ref.RequestURI()) {
+		target = ref.RequestURI()
+	}
+	http.Redirect(w, r, target, http.StatusSeeOther)
+}
+
+// localPath is true for a path on this server, like "/" or "/remote?x=1", and
+// false for anything a browser would take to another host.
+func localPath(p string) bool {
+	if !strings.HasPrefix(p, "/") || strings.HasPrefix(p, "//") || strings.HasPrefix(p, "/\\") {
+		return false
+	}
+	u, err := url.Parse(p)
+	return err == nil && u.Scheme == "" && u.Host == ""
+}
+
+// errHandler is basicHandler for methods that wait for the reply themselves.
+func errHandler(f func(*MPVClient) error) func(http.ResponseWriter, *http.Request) {
+	return basicHandler(func(mc *MPVClient) (<-chan []byte, error) {
+		if err := f(mc); err != nil {
+			return nil, err
+		}
+		return replied([]byte(`{"error": "success"}`)), nil
+	})
+}
+
+// formValues is every value of the parameter name, from the query string or a
+// posted form.
+func formValues(r *http.Request, name string) []string {
+	r.ParseForm()
+	return r.Form[name]
+}
+
+// queryHandler is basicHandler for commands that take a single argument from
+// the query string or a posted form.
+func queryHandler(param string, f func(*MPVClient, string) (<-chan []byte, error)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		basicHandler(func(mc *MPVClient) (<-chan []byte, error) {
+			return f(mc, r.FormValue(param))
+		})(w, r)
+	}
+}
+
+// floatHandler is queryHandler for commands that take a number.
+func floatHandler(param string, f func(*MPVClient, float64) (<-chan []byte, error)) func(http.ResponseWriter, *http.Request) {
+	return queryHandler(param, func(mc *MPVClient, v string) (<-chan []byte, error) {
+		n, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrBadArgument, param, err)
+		}
+		return f(mc, n)
+	})
+}
+
+// shared adapts a command that isn't sent for any one request, like the
+// coalesced ones, to floatHandler. What it sends has no trace id.
+func shared(f func(float64) (<-chan []byte, error)) func(*MPVClient, float64) (<-chan []byte, error) {
+	return func(_ *MPVClient, v float64) (<-chan []byte, error) { return f(v) }
+}
+
+// intHandler is queryHandler for commands that take a whole number.
+func intHandler(param string, f func(*MPVClient, int) (<-chan []byte, error)) func(http.ResponseWriter, *http.Request) {
+	return queryHandler(param, func(mc *MPVClient, v string) (<-chan []byte, error) {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrBadArgument, param, err)
+		}
+		return f(mc, n)
+	})
+}
+
+// parseBool reads a yes/no query parameter. It takes 1/0, true/false and
+// yes/no in any case, and def if the parameter isn't there at all.
+func parseBool(r *http.Request, name string, def bool) (bool, error) {
+	v := r.FormValue(name)
+	switch strings.ToLower(v) {
+	case "":
+		return def, nil
+	case "1", "true", "yes":
+		return true, nil
+	case "0", "false", "no":
+		return false, nil
+	}
+	return false, fmt.Errorf("%w: %s must be 1/0, true/false or yes/no, got %q", ErrBadArgument, name, v)
+}
+
+// boolHandler is basicHandler for on/off commands, which default to on.
+func boolHandler(param string, f func(*MPVClient, bool) (<-chan []byte, error)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		basicHandler(func(mc *MPVClient) (<-chan []byte, error) {
+			on, err := parseBool(r, param, true)
+			if err != nil {
+				return nil, err
+			}
+			return f(mc, on)
+		})(w, r)
+	}
+}
+
+// jsonHandler is basicHandler for the read only endpoints, it writes the
+// value out as JSON instead of redirecting.
+func jsonHandler(f func(*MPVClient) (interface{}, error)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		v, err := f(requestClient(r))
+		if err != nil {
+			httpError(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(v); err != nil {
+			tracef(r, "%v", err)
+		}
+	}
+}
+
+// eventsHandler streams mpv events to the browser as server-sent events,
+// until the client goes away.
+//
+// A client can ask for properties to be observed for it when it connects,
+// with ?observe=pause,time-pos. It then gets changes to those, and not the
+// property changes other clients asked for. The properties are unobserved
+// again when it goes away.
+func eventsHandler(mc *MPVClient) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Subscribe first, so the initial value sent on observing isn't
+		// missed.
+		events, unsub := mc.Subscribe()
+		defer unsub()
+
+		var ids map[int64]bool
+		for _, list := range r.URL.Query()["observe"] {
+			for _, name := range strings.Split(list, ",") {
+				if name == "" {
+					continue
+				}
+				id, err := mc.ObserveProperty(name)
+				if err != nil {
+					httpError(w, r, fmt.Errorf("observing %s: %w", name, err))
+					return
+				}
+				defer func() {
+					if err := mc.UnobserveProperty(id); err != nil {
+						tracef(r, "%v", err)
+					}
+				}()
+				if ids == nil {
+					ids = make(map[int64]bool)
+				}
+				ids[id] = true
+			}
+		}
+
+		streamEvents(w, r, events, func(ev []byte) bool {
+			return ids == nil || !isPropertyChange(ev) || observedBy(ev, ids)
+		})
+	}
+}
+
+// streamEvents sends the events that keep is true for as server-sent events,
+// until the client goes away.
+func streamEvents(w http.ResponseWriter, r *http.Request, events <-chan []byte, keep func([]byte) bool) {
+	fl, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	fl.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case ev := <-events:
+			if !keep(ev) {
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "data: %s\n\n", bytes.TrimSpace(ev)); err != nil {
+				return
+			}
+			fl.Flush()
+		}
+	}
+}
+
+// compositeHandler fires all the commands before waiting on any reply, so
+// they go out back to back, and only redirects once every reply is in.
+func compositeHandler(fs ...func(*MPVClient) (<-chan []byte, error)) func(http.ResponseWriter, *http.Request) {
+	return compositeThen(nil, fs...)
+}
+
+// compositeThen is compositeHandler, also running then with the request's
+// client if mpv said yes to every command.
+func compositeThen(then func(*MPVClient), fs ...func(*MPVClient) (<-chan []byte, error)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tracef(r, "sending %d commands for %s", len(fs), r.URL)
+		mc := requestClient(r)
+		chs := make([]<-chan []byte, 0, len(fs))
+		for _, f := range fs {
+			res, err := f(mc)
+			if err != nil {
+				httpError(w, r, err)
+				return
+			}
+			chs = append(chs, res)
+		}
+		replies := make([]Envelope, 0, len(chs))
+		ok := true
+		for _, res := range chs {
+			reply := <-res
+			tracef(r, "reply: %s", reply)
+			replies = append(replies, newEnvelope(reply))
+			if _, err := replyData(reply); err != nil {
+				ok = false
+			}
+		}
+		if ok && then != nil {
+			then(mc)
+		}
+		if wantsJSON(r) {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(replies)
+			return
+		}
+		redirectBack(w, r)
+	}
+}
+
+// validateAddr checks that addr is something we can listen on, so a typo
+// gives a clear error instead of whatever net makes of it.
+func validateAddr(addr string) error {
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		if path == "" {
+			return fmt.Errorf("invalid -addr %q: no socket path after unix:", addr)
+		}
+		return nil
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid -addr %q, want host:port, [ipv6]:port or unix:/path: %v", addr, err)
+	}
+
+	if p, err := strconv.Atoi(port); err != nil || p < 0 || p > 65535 {
+		return fmt.Errorf("invalid -addr %q: port %q is not a number between 0 and 65535", addr, port)
+	}
+
+	// netip, unlike net.ParseIP, takes zoned addresses like fe80::1%eth0.
+	if _, err := netip.ParseAddr(host); host == "" || err == nil {
+		return nil
+	}
+	if strings.Contains(host, ":") {
+		return fmt.Errorf("invalid -addr %q: %q is not a valid IPv6 address", addr, host)
+	}
+	for _, label := range strings.Split(host, ".") {
+		if !validHostLabel(label) {
+			return fmt.Errorf("invalid -addr %q: %q is not a valid hostname", addr, host)
+		}
+	}
+	return nil
+}
+
+// listen listens on addr, which is host:port for TCP or unix:/path for a Unix
+// socket. A socket left over from an earlier run is removed first, but
+// nothing else that might be at path.
+func listen(addr string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(addr, "unix:")
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	return net.Listen("unix", path)
+}
+
+func validHostLabel(label string) bool {
+	if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+		return false
+	}
+	for _, c := range label {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+func main() {
+	coalesceWindow := flag.Duration("coalesce", 0, "collapse relative volume and seek requests within this window into one command, 0 to disable")
+	priority := flag.String("priority", strings.Join(DefaultPriority.Names(), ","), "commands and properties that are written ahead of everything else")
+	pipe := flag.String("pipe", `\\.\pipe\mpv_socket`, "the mpv IPC pipe to control at startup")
+	pipePattern := flag.String("pipePattern", "mpv*", `pattern for the pipe names under \\.\pipe\ offered as mpv instances`)
+	idleTimeout := flag.Duration("idleTimeout", 0, "connect to mpv on first use and disconnect after being idle this long, 0 to stay connected")
+	addr := flag.String("addr", "192.168.1.177:3333", "address to serve on, host:port, with IPv6 hosts in brackets, or unix:/path for a Unix socket")
+	macroFile := flag.String("macros", "", "JSON file with named macros to serve under /api/macro/")
+	allowRun := flag.Bool("allowRun", false, "allow /api/run to start programs on the mpv machine, DANGEROUS: anyone who can reach the server can run anything")
+	maxLine := flag.Int("maxLine", 16<<20, "skip lines from mpv longer than this many bytes, 0 for no limit")
+	replyTimeout := flag.Duration("replyTimeout", 30*time.Second, "how long to wait for mpv to reply to a command, 0 for no limit")
+	commandLogFile := flag.String("commandLog", "", "append every command sent to mpv and its reply to this file")
+	statusConcurrency := flag.Int("statusConcurrency", 4, "how many properties /api/status asks mpv for at once")
+	speedUp := flag.Float64("speedUp", 1.25, "factor /api/speedUp multiplies the speed by")
+	speedDown := flag.Float64("speedDown", 0.8, "factor /api/speedDown multiplies the speed by")
+	speedMin := flag.Float64("speedMin", 0.25, "lowest speed the speed controls go to")
+	speedMax := flag.Float64("speedMax", 4, "highest speed the speed controls go to")
+	eventHistory := flag.Int("eventHistory", 100, "how many of the latest mpv events /debug/events shows")
+	browseRoot := flag.String("browseRoot", "", "directory /api/browse lists files under, empty to disable browsing")
+	maxInflight := flag.Int("maxInflight", 0, "how many commands may wait for mpv at once before new ones get a 503, 0 for no limit")
+	retries := flag.Int("retries", 1, "how many times an idempotent command is tried when writing it to mpv fails")
+	retryBackoff := flag.Duration("retryBackoff", 50*time.Millisecond, "wait before the first retry, doubled for each one after")
+	onEndFile := flag.String("onEndFile", "", "URL to POST the end-file event to every time a file finishes, empty to disable")
+	muteWhileSeeking := flag.Duration("muteWhileSeeking", 0, "mute while seeking or scrubbing, until no seek has come for this long, 0 to disable")
+	workers := flag.Int("workers", 32, "how many HTTP requests are handled at once before new ones get a 503, 0 for no limit")
+	oscHideAfter := flag.Duration("oscHideAfter", 3*time.Second, "how long after /api/pauseWithOsc unpauses the OSC is hidden again, 0 to leave it up")
+	instanceNames := flag.String("instanceNames", "", "JSON file to keep friendly instance names in, empty to only keep them until we exit")
+	groups := flag.String("groups", "", `instances commands can be mirrored to at once, like "living=mpv-left,mpv-right;bedroom=mpv-bed"`)
+	bookmarkFile := flag.String("bookmarks", "", "JSON file to keep bookmarks in, empty to only keep them until we exit")
+	flag.BoolVar(&apiOnly, "apiOnly", false, "only serve /api, without the HTML controls")
+	flag.Parse()
+
+	if err := validateAddr(*addr); err != nil {
+		log.Fatal(err)
+	}
+
+	// Setup logger
+	log.SetFlags(log.Flags() | log.Llongfile)
+
+	var mc *MPVClient
+	if *idleTimeout > 0 {
+		mc = NewLazyMPVClient(*pipe, *idleTimeout)
+	} else {
+		var err error
+		mc, err = NewMPVClient(*pipe)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	defer mc.Close()
+	mc.SetPriority(NewPriority(strings.Split(*priority, ",")...))
+	mc.SetRetryPolicy(RetryPolicy{Attempts: *retries, Backoff: *retryBackoff})
+	mc.SetMaxLineSize(*maxLine)
+	mc.SetReplyTimeout(*replyTimeout)
+	mc.SetMaxInflight(*maxInflight)
+	mc.SetStatusConcurrency(*statusConcurrency)
+	mc.SetEventHistory(*eventHistory)
+	if *commandLogFile != "" {
+		f, err := os.OpenFile(*commandLogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		mc.SetCommandLog(f)
+	}
+
+	mc.checkVersion()
+	mc.WatchBuffering()
+	mc.WatchPlaylist()
+	mc.WatchSeeking()
+
+	if *onEndFile != "" {
+		mc.OnEvent("end-file", webhook(*onEndFile))
+	}
+
+	r := chi.NewRouter()
+	r.Use(traceMiddleware)
+	r.Use(withClient(mc))
+	r.Use(newHandlerPool(*workers, "/api/events", "/api/logs").middleware)
+
+	// Anything that changes what mpv is doing only answers POST, so that
+	// prefetching or crawling a link can't. GET is for reading.
+
+	// The controls on the root page
+	controls := controlTable()
+	registerControls(r, controls)
+	if !apiOnly {
+		r.Get("/", rootHandler(controls))
+	}
+
+	// Instances
+	names, err := LoadInstanceNames(*instanceNames)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cm := NewClientManager(mc, names, *pipe, *pipePattern)
+	r.Get("/api/instances", jsonHandler(func(*MPVClient) (interface{}, error) { return cm.Instances() }))
+	r.Post("/api/instances/select", func(w http.ResponseWriter, r *http.Request) {
+		errHandler(func(*MPVClient) error { return cm.Select(r.FormValue("name")) })(w, r)
+	})
+
+	// Friendly names for instances, ?name=living&pipe=mpv-left
+	r.Get("/api/instances/names", jsonHandler(func(*MPVClient) (interface{}, error) { return names.All(), nil }))
+	r.Post("/api/instances/names/set", func(w http.ResponseWriter, r *http.Request) {
+		errHandler(func(*MPVClient) error { return names.Set(r.FormValue("name"), r.FormValue("pipe")) })(w, r)
+	})
+	r.Post("/api/instances/names/rename", func(w http.ResponseWriter, r *http.Request) {
+		errHandler(func(*MPVClient) error { return names.Rename(r.FormValue("from"), r.FormValue("to")) })(w, r)
+	})
+	r.Post("/api/instances/names/remove", func(w http.ResponseWriter, r *http.Request) {
+		errHandler(func(*MPVClient) error { return names.Remove(r.FormValue("name")) })(w, r)
+	})
+
+	// Groups of instances, /api/group/living/pauseToggle
+	gs, err := ParseGroups(*groups)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cm.SetGroups(gs)
+	r.Post("/api/group/:name/:command", groupHandler(cm))
+
+	// Pause
+	r.Post("/api/pause", playing(boolHandler("on", (*MPVClient).SetPause)))
+	// The OSC goes first, so without it nothing is done. It is only hidden
+	// again if it was shown.
+	hideOSC := func(mc *MPVClient) {
+		if *oscHideAfter > 0 {
+			mc.hideOSCLater(*oscHideAfter)
+		}
+	}
+	r.Post("/api/pauseWithOsc", playing(compositeThen(hideOSC, (*MPVClient).OSCOn, (*MPVClient).PauseToggle)))
+
+	// OSC, never -> auto -> always
+	r.Post("/api/oscCycle", jsonHandler(func(mc *MPVClient) (interface{}, error) {
+		mode, err := mc.OSCCycle()
+		return map[string]string{"mode": mode}, err
+	}))
+
+	// Load, ?path=&mode=&start=&end=
+	r.Post("/api/loadfile", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		q := r.Form
+		basicHandler(func(mc *MPVClient) (<-chan []byte, error) {
+			if q.Get("path") == "" {
+				return nil, fmt.Errorf("%w: no path given", ErrBadArgument)
+			}
+			mode := q.Get("mode")
+			if mode == "" {
+				mode = "replace"
+			}
+			opts := map[string]string{}
+			for _, k := range []string{"start", "end"} {
+				if v := q.Get(k); v != "" {
+					opts[k] = v
+				}
+			}
+			return mc.LoadFileWithOpts(q.Get("path"), mode, opts)
+		})(w, r)
+	})
+
+	// Browse the files on the mpv machine, ?dir= relative to -browseRoot
+	r.Get("/api/browse", func(w http.ResponseWriter, r *http.Request) {
+		if *browseRoot == "" {
+			http.Error(w, "browsing is disabled, start with -browseRoot to enable it", http.StatusForbidden)
+			return
+		}
+		jsonHandler(func(*MPVClient) (interface{}, error) { return Browse(*browseRoot, r.URL.Query().Get("dir")) })(w, r)
+	})
+
+	// Reload
+	r.Post("/api/reload", errHandler((*MPVClient).ReloadCurrent))
+
+	// Quit
+	r.Post("/api/safeQuit", basicHandler((*MPVClient).SafeQuit))
+
+	// Window
+	r.Post("/api/border", boolHandler("on", (*MPVClient).SetBorder))
+	r.Post("/api/taskbarProgress", boolHandler("on", (*MPVClient).SetTaskbarProgress))
+	r.Get("/api/title", jsonHandler(func(mc *MPVClient) (interface{}, error) { return mc.GetTitle() }))
+	r.Post("/api/title", queryHandler("value", (*MPVClient).SetTitle))
+
+	// Looping
+	r.Post("/api/loopPlaylist", queryHandler("mode", (*MPVClient).SetLoopPlaylist))
+	r.Post("/api/loopFile", queryHandler("count", (*MPVClient).SetLoopFile))
+	r.Post("/api/loopCycle", jsonHandler(func(mc *MPVClient) (interface{}, error) {
+		mode, err := mc.LoopCycle()
+		return map[string]string{"loop-playlist": mode}, err
+	}))
+
+	// Playlist
+	r.Post("/api/playlistJump", intHandler("offset", (*MPVClient).PlaylistJump))
+
+	// Chapters
+	r.Post("/api/chapterAdd", playing(intHandler("n", (*MPVClient).AddChapter)))
+	r.Post("/api/chapterFind", playing(func(w http.ResponseWriter, r *http.Request) {
+		errHandler(func(mc *MPVClient) error { return mc.SeekChapterByTitle(r.FormValue("q")) })(w, r)
+	}))
+
+	// Relative adjustments
+	volumeAdd := newCoalescer(*coalesceWindow, mc.AddVolume)
+	seek := newCoalescer(*coalesceWindow, mc.Seek)
+	r.Post("/api/volumeAdd", floatHandler("delta", shared(volumeAdd.Add)))
+	muter := newSeekMuter(mc, *muteWhileSeeking)
+	r.Post("/api/seek", playing(floatHandler("delta", shared(muter.wrap(seek.Add)))))
+	r.Post("/api/scrub", playing(floatHandler("percent", func(mc *MPVClient, percent float64) (<-chan []byte, error) {
+		return muter.wrap(mc.Scrub)(percent)
+	})))
+	r.Post("/api/seekStart", playing(basicHandler((*MPVClient).SeekStart)))
+	r.Post("/api/seekEnd", playing(basicHandler((*MPVClient).SeekEnd)))
+
+	// Cycle a property through ?value=a&value=b&...
+	r.Post("/api/cycleValues", func(w http.ResponseWriter, r *http.Request) {
+		basicHandler(func(mc *MPVClient) (<-chan []byte, error) {
+			return mc.CycleValues(r.FormValue("property"), formValues(r, "value")...)
+		})(w, r)
+	})
+
+	// Aspect ratio
+	r.Post("/api/aspect", queryHandler("value", (*MPVClient).SetAspect))
+	r.Post("/api/aspectCycle", basicHandler((*MPVClient).AspectCycle))
+
+	// Rotation, ?deg=90
+	r.Post("/api/rotate", intHandler("deg", (*MPVClient).SetRotate))
+	r.Post("/api/rotateCycle", basicHandler((*MPVClient).RotateCycle))
+
+	// Speed
+	speedMul := func(mc *MPVClient, factor float64) (<-chan []byte, error) {
+		return mc.MultiplySpeed(factor, *speedMin, *speedMax)
+	}
+	r.Post("/api/speedMul", playing(floatHandler("factor", speedMul)))
+	r.Post("/api/speedMulOsd", playing(floatHandler("factor", func(mc *MPVClient, factor float64) (<-chan []byte, error) {
+		return mc.MultiplySpeedOSD(factor, *speedMin, *speedMax)
+	})))
+	r.Post("/api/speedUp", playing(basicHandler(func(mc *MPVClient) (<-chan []byte, error) { return speedMul(mc, *speedUp) })))
+	r.Post("/api/speedDown", playing(basicHandler(func(mc *MPVClient) (<-chan []byte, error) { return speedMul(mc, *speedDown) })))
+
+	// Transport controls, for building a remote against. Everything here
+	// is a POST and answers with an Envelope whatever -apiOnly says, or
+	// {"error": "..."} with the same status codes as elsewhere:
+	//
+	//	/api/transport/play
+	//	/api/transport/pause
+	//	/api/transport/toggle
+	//	/api/transport/stop
+	//	/api/transport/next             next in the playlist
+	//	/api/transport/prev
+	//	/api/transport/seek?delta=10    seconds, negative to go back
+	//	/api/transport/speed?factor=1.5 multiplies, within -speedMin and -speedMax
+	r.Route("/api/transport", func(r chi.Router) {
+		r.Use(jsonReplies)
+		r.Post("/play", playing(basicHandler((*MPVClient).PauseOff)))
+		r.Post("/pause", playing(basicHandler((*MPVClient).PauseOn)))
+		r.Post("/toggle", playing(basicHandler((*MPVClient).PauseToggle)))
+		r.Post("/stop", basicHandler((*MPVClient).Stop))
+		r.Post("/next", basicHandler((*MPVClient).PlaylistNext))
+		r.Post("/prev", basicHandler((*MPVClient).PlaylistPrev))
+		r.Post("/seek", playing(floatHandler("delta", shared(muter.wrap(seek.Add)))))
+		r.Post("/speed", playing(floatHandler("factor", speedMul)))
+	})
+
+	// Pan and zoom
+	r.Post("/api/zoom", floatHandler("delta", (*MPVClient).NudgeZoom))
+	r.Post("/api/panX", floatHandler("delta", (*MPVClient).NudgePanX))
+	r.Post("/api/panY", floatHandler("delta", (*MPVClient).NudgePanY))
+	r.Post("/api/panReset", compositeHandler(
+		func(mc *MPVClient) (<-chan []byte, error) { return mc.SetZoom(0) },
+		func(mc *MPVClient) (<-chan []byte, error) { return mc.SetPanX(0) },
+		func(mc *MPVClient) (<-chan []byte, error) { return mc.SetPanY(0) },
+	))
+
+	// Subtitle styling
+	r.Post("/api/subScale", floatHandler("delta", (*MPVClient).NudgeSubScale))
+	r.Post("/api/subPos", floatHandler("delta", (*MPVClient).NudgeSubPos))
+	r.Post("/api/subColor", queryHandler("value", (*MPVClient).SetSubColor))
+	// ?name=&size=, either or both. The size goes first, so a bad one
+	// leaves the font alone too.
+	r.Post("/api/subFont", func(w http.ResponseWriter, r *http.Request) {
+		var fs []func(*MPVClient) (<-chan []byte, error)
+		if v := r.FormValue("size"); v != "" {
+			size, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				httpError(w, r, fmt.Errorf("%w: size: %v", ErrBadArgument, err))
+				return
+			}
+			fs = append(fs, func(mc *MPVClient) (<-chan []byte, error) { return mc.SetSubFontSize(size) })
+		}
+		if name := r.FormValue("name"); name != "" {
+			fs = append(fs, func(mc *MPVClient) (<-chan []byte, error) { return mc.SetSubFont(name) })
+		}
+		if len(fs) == 0 {
+			httpError(w, r, fmt.Errorf("%w: need a name or a size", ErrBadArgument))
+			return
+		}
+		compositeHandler(fs...)(w, r)
+	})
+	r.Post("/api/subSeek", playing(intHandler("n", (*MPVClient).SubSeek)))
+	r.Post("/api/secondarySub", queryHandler("id", (*MPVClient).SetSecondarySub))
+	r.Post("/api/secondarySubToggle", basicHandler((*MPVClient).ToggleSecondarySub))
+
+	// Network streams
+	r.Post("/api/userAgent", queryHandler("value", (*MPVClient).SetUserAgent))
+	r.Post("/api/referrer", queryHandler("value", (*MPVClient).SetReferrer))
+	r.Post("/api/httpHeaders", func(w http.ResponseWriter, r *http.Request) {
+		basicHandler(func(mc *MPVClient) (<-chan []byte, error) {
+			return mc.SetHTTPHeaders(formValues(r, "value")...)
+		})(w, r)
+	})
+
+	// Status
+	r.Get("/api/status", jsonHandler(func(mc *MPVClient) (interface{}, error) { return mc.Status() }))
+	r.Get("/api/session", jsonHandler(func(mc *MPVClient) (interface{}, error) { return mc.Session() }))
+	r.Get("/api/timeRemaining", jsonHandler(func(mc *MPVClient) (interface{}, error) { return mc.TimeRemaining() }))
+
+	r.Get("/api/cacheState", jsonHandler(func(mc *MPVClient) (interface{}, error) { return mc.CacheState() }))
+	r.Get("/api/seeking", jsonHandler(func(mc *MPVClient) (interface{}, error) {
+		return map[string]bool{"seeking": mc.Seeking()}, nil
+	}))
+	r.Get("/api/loudness", jsonHandler(func(mc *MPVClient) (interface{}, error) { return mc.Loudness() }))
+	r.Get("/api/videoInfo", playing(jsonHandler(func(mc *MPVClient) (interface{}, error) { return mc.VideoInfo() })))
+	r.Get("/api/buffering", jsonHandler(func(mc *MPVClient) (interface{}, error) {
+		percent, err := mc.CacheBufferingState()
+		return map[string]int{"percent": percent}, err
+	}))
+
+	// Property expansion, ?text=${time-pos}
+	r.Get("/api/expand", func(w http.ResponseWriter, r *http.Request) {
+		jsonHandler(func(mc *MPVClient) (interface{}, error) {
+			text, err := mc.ExpandText(r.URL.Query().Get("text"))
+			return map[string]string{"text": text}, err
+		})(w, r)
+	})
+
+	// Print to mpv's terminal, ?text=${time-pos}
+	r.Post("/api/printText", queryHandler("text", (*MPVClient).PrintText))
+
+	r.Get("/api/idleActive", jsonHandler(func(mc *MPVClient) (interface{}, error) { return mc.IsIdle() }))
+
+	// Now playing
+	r.Get("/api/nowPlaying", jsonHandler(func(mc *MPVClient) (interface{}, error) {
+		title, err := mc.NowPlaying()
+		return map[string]string{"title": title}, err
+	}))
+
+	// Bookmarks, ?name=
+	bookmarks, err := LoadBookmarks(*bookmarkFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	r.Get("/api/bookmarks", jsonHandler(func(*MPVClient) (interface{}, error) { return bookmarks.All(), nil }))
+	r.Get("/api/bookmark", func(w http.ResponseWriter, r *http.Request) {
+		jsonHandler(func(*MPVClient) (interface{}, error) { return bookmarks.Get(r.URL.Query().Get("name")) })(w, r)
+	})
+	r.Post("/api/bookmark", func(w http.ResponseWriter, r *http.Request) {
+		jsonHandler(func(mc *MPVClient) (interface{}, error) { return mc.SaveBookmark(bookmarks, r.FormValue("name")) })(w, r)
+	})
+	r.Post("/api/bookmark/jump", func(w http.ResponseWriter, r *http.Request) {
+		errHandler(func(mc *MPVClient) error { return mc.JumpToBookmark(bookmarks, r.FormValue("name")) })(w, r)
+	})
+
+	// Snapshots, ?prop=volume&prop=speed or the defaults. The snapshot is
+	// restored by posting it back as is.
+	r.Get("/api/snapshot", func(w http.ResponseWriter, r *http.Request) {
+		jsonHandler(func(mc *MPVClient) (interface{}, error) { return mc.SnapshotState(r.URL.Query()["prop"]) })(w, r)
+	})
+	r.Post("/api/restore", func(w http.ResponseWriter, r *http.Request) {
+		jsonHandler(func(mc *MPVClient) (interface{}, error) {
+			var state map[string]json.RawMessage
+			if err := json.NewDecoder(r.Body).Decode(&state); err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrBadArgument, err)
+			}
+			failed, err := mc.RestoreState(state)
+			return map[string]interface{}{"failed": failed}, err
+		})(w, r)
+	})
+
+	// Profiles, a JSON object of properties to set. With ?rollback=1 the
+	// ones that were set are put back if any of the others fail.
+	r.Post("/api/profile", func(w http.ResponseWriter, r *http.Request) {
+		jsonHandler(func(mc *MPVClient) (interface{}, error) {
+			rollback, err := parseBool(r, "rollback", false)
+			if err != nil {
+				return nil, err
+			}
+			var props map[string]interface{}
+			if err := json.NewDecoder(r.Body).Decode(&props); err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrBadArgument, err)
+			}
+
+			var applied []string
+			var failed map[string]error
+			if rollback {
+				applied, failed, err = mc.ApplyProfileOrRollback(props)
+			} else {
+				applied, failed = mc.ApplyProfile(props)
+			}
+			reasons := make(map[string]string, len(failed))
+			for name, ferr := range failed {
+				reasons[name] = ferr.Error()
+			}
+			if applied == nil {
+				applied = []string{}
+			}
+			return map[string]interface{}{"applied": applied, "failed": reasons}, err
+		})(w, r)
+	})
+
+	// mpv version, and if we have tested with it
+	r.Get("/api/version", jsonHandler(func(mc *MPVClient) (interface{}, error) { return mc.MPVVersion() }))
+
+	// Keys, ?name=Ctrl+RIGHT. The "+" may be sent as is or as %2B.
+	r.Post("/api/key", queryHandler("name", func(mc *MPVClient, name string) (<-chan []byte, error) {
+		return mc.KeyPress(queryKey(name))
+	}))
+	r.Get("/api/bindings", jsonHandler(func(mc *MPVClient) (interface{}, error) { return mc.GetInputBindings() }))
+
+	// Editions
+	r.Get("/api/editions", jsonHandler(func(mc *MPVClient) (interface{}, error) { return mc.GetEditionList() }))
+	r.Get("/api/edition", jsonHandler(func(mc *MPVClient) (interface{}, error) { return mc.GetEdition() }))
+	r.Post("/api/edition", playing(intHandler("index", (*MPVClient).SetEdition)))
+
+	// Audio output
+	r.Get("/api/audioDevices", jsonHandler(func(mc *MPVClient) (interface{}, error) { return mc.GetAudioDevices() }))
+	r.Post("/api/audioDevice", queryHandler("name", (*MPVClient).SetAudioDevice))
+	r.Post("/api/audioDeviceCycle", jsonHandler(func(mc *MPVClient) (interface{}, error) { return mc.AudioDeviceCycle() }))
+
+	// Hardware decoding
+	r.Get("/api/hwdecCurrent", jsonHandler(func(mc *MPVClient) (interface{}, error) {
+		cur, err := mc.GetHWDecCurrent()
+		if unavailable(err) {
+			cur, err = "no", nil
+		}
+		return map[string]interface{}{"hwdec-current": cur, "active": cur != "no" && cur != ""}, err
+	}))
+
+	// Tracks
+	r.Get("/api/tracks", jsonHandler(func(mc *MPVClient) (interface{}, error) { return mc.GetTrackList() }))
+
+	// End of playback
+	r.Post("/api/keepOpen", queryHandler("mode", (*MPVClient).SetKeepOpen))
+	r.Post("/api/idle", queryHandler("mode", (*MPVClient).SetIdle))
+
+	// A/V sync
+	r.Post("/api/videoSync", queryHandler("mode", (*MPVClient).SetVideoSync))
+
+	// Gapless music playback
+	r.Post("/api/gapless", queryHandler("mode", (*MPVClient).SetGaplessAudio))
+	r.Post("/api/audioBuffer", floatHandler("value", (*MPVClient).SetAudioBuffer))
+
+	// Screenshots, ?path=C:\shots\a.png&mode=video
+	r.Post("/api/screenshotTo", func(w http.ResponseWriter, r *http.Request) {
+		basicHandler(func(mc *MPVClient) (<-chan []byte, error) {
+			mode := r.FormValue("mode")
+			if mode == "" {
+				mode = "subtitles"
+			}
+			return mc.ScreenshotToFile(r.FormValue("path"), mode)
+		})(w, r)
+	})
+
+	// External programs, ?arg=program&arg=...
+	r.Post("/api/run", func(w http.ResponseWriter, r *http.Request) {
+		if !*allowRun {
+			http.Error(w, "running programs is disabled, start with -allowRun to enable it", http.StatusForbidden)
+			return
+		}
+		basicHandler(func(mc *MPVClient) (<-chan []byte, error) {
+			return mc.Run(formValues(r, "arg")...)
+		})(w, r)
+	})
+
+	// Macros
+	if *macroFile != "" {
+		ms, err := LoadMacros(*macroFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		r.Post("/api/macro/:name", macroHandler(ms))
+	}
+
+	// Events
+	r.Get("/api/events", eventsHandler(mc))
+
+	// mpv's log, once turned on with ?level=info
+	r.Post("/api/logs/enable", queryHandler("level", (*MPVClient).RequestLogMessages))
+	r.Get("/api/logs", logsHandler(mc))
+	r.Get("/debug/events", jsonHandler(func(mc *MPVClient) (interface{}, error) { return mc.RecentEvents(), nil }))
+
+	l, err := listen(*addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Fatal(http.Serve(l, r))
 }
@@ -0,0 +1,775 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// request is a command as the fake mpv sees it.
+type request struct {
+	Command   []interface{} `json:"command"`
+	RequestID json.Number   `json:"request_id"`
+}
+
+// fakeMPV is the far end of a net.Pipe, standing in for mpv. Every command
+// the client writes shows up in reqs, and is answered by whatever the test
+// sends back.
+type fakeMPV struct {
+	t    testing.TB
+	conn net.Conn
+	reqs chan request
+}
+
+// newFakeMPV returns a client talking to a fake mpv over a net.Pipe. Both are
+// closed when the test ends, mpv first so Close doesn't wait for replies that
+// were never sent.
+func newFakeMPV(t testing.TB) (*MPVClient, *fakeMPV) {
+	a, b := net.Pipe()
+	mc := NewMPVClientConn(a)
+	t.Cleanup(func() { mc.Close() })
+	return mc, startFakeMPV(t, b)
+}
+
+func startFakeMPV(t testing.TB, conn net.Conn) *fakeMPV {
+	f := &fakeMPV{t: t, conn: conn, reqs: make(chan request, 1024)}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		rd := bufio.NewReader(conn)
+		for {
+			line, err := rd.ReadBytes('\n')
+			if err != nil {
+				return
+			}
+			var r request
+			if err := json.Unmarshal(line, &r); err != nil {
+				t.Errorf("client sent invalid JSON %q: %v", line, err)
+				return
+			}
+			f.reqs <- r
+		}
+	}()
+	return f
+}
+
+// next waits for the next command the client sends.
+func (f *fakeMPV) next() request {
+	f.t.Helper()
+	select {
+	case r := <-f.reqs:
+		return r
+	case <-time.After(5 * time.Second):
+		f.t.Fatal("timed out waiting for a command")
+		return request{}
+	}
+}
+
+// send writes a line to the client as it is.
+func (f *fakeMPV) send(line string) {
+	f.t.Helper()
+	if _, err := f.conn.Write([]byte(line + "\n")); err != nil {
+		f.t.Fatal(err)
+	}
+}
+
+// reply answers r successfully with data, which must be JSON.
+func (f *fakeMPV) reply(r request, data string) {
+	f.t.Helper()
+	f.send(fmt.Sprintf(`{"data": %s, "request_id": %s, "error": "success"}`, data, r.RequestID))
+}
+
+// serve answers every command from now on with the command itself as data.
+func (f *fakeMPV) serve() {
+	go func() {
+		for r := range f.reqs {
+			data, _ := json.Marshal(r.Command)
+			line := fmt.Sprintf(`{"data": %s, "request_id": %s, "error": "success"}`+"\n", data, r.RequestID)
+			if _, err := f.conn.Write([]byte(line)); err != nil {
+				return
+			}
+		}
+	}()
+}
+
+// recv waits for the reply on res.
+func recv(t *testing.T, res <-chan []byte) []byte {
+	t.Helper()
+	select {
+	case reply := <-res:
+		return reply
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a reply")
+		return nil
+	}
+}
+
+// failWrites is a connection nothing can be written to.
+type failWrites struct{ net.Conn }
+
+var errWriteFailed = errors.New("write failed")
+
+func (failWrites) Write([]byte) (int, error) { return 0, errWriteFailed }
+
+// closeWithin fails the test if mc.Close takes longer than d.
+func closeWithin(t *testing.T, mc *MPVClient, d time.Duration) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		mc.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(d):
+		t.Fatal("Close hung")
+	}
+}
+
+func TestFailedWriteIsNotCounted(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	mc := NewMPVClientConn(failWrites{a})
+
+	if _, err := mc.Command("get_property", "pause"); !errors.Is(err, errWriteFailed) {
+		t.Fatalf("got %v, want the write error", err)
+	}
+
+	mc.i2cMtx.Lock()
+	n := len(mc.i2c)
+	mc.i2cMtx.Unlock()
+	if n != 0 {
+		t.Errorf("%d commands still waiting for a reply after a failed write", n)
+	}
+
+	// Had the failed command been added to wg, this would wait forever.
+	closeWithin(t, mc, time.Second)
+}
+
+func TestCloseWaitsForReplies(t *testing.T) {
+	mc, mpv := newFakeMPV(t)
+
+	res, err := mc.Command("get_property", "pause")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := mpv.next()
+
+	closed := make(chan struct{})
+	go func() {
+		mc.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+		t.Fatal("Close returned with a reply still owed")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	mpv.reply(r, "false")
+	recv(t, res)
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Close hung after the last reply came")
+	}
+}
+
+func TestReplyWithoutRequestID(t *testing.T) {
+	mc, mpv := newFakeMPV(t)
+
+	res, err := mc.Command("get_property", "pause")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := mpv.next()
+
+	// Neither of these can be routed, so they are dropped. The monitor has
+	// to live through them to hand out the real reply.
+	mpv.send(`{"data": true, "error": "success"}`)
+	mpv.send(`{"data": true, "request_id": "abc", "error": "success"}`)
+	mpv.reply(r, "false")
+
+	data, err := replyData(recv(t, res))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "false" {
+		t.Errorf("got %s, want the reply to our own command", data)
+	}
+}
+
+func TestValidateAddr(t *testing.T) {
+	for _, tc := range []struct {
+		addr string
+		ok   bool
+	}{
+		{"127.0.0.1:3333", true},
+		{":3333", true},
+		{"[::1]:3333", true},
+		{"[fe80::1%eth0]:3333", true},
+		{"localhost:3333", true},
+		{"media-pc.lan:80", true},
+		{"unix:/run/mpvctrl.sock", true},
+
+		{"127.0.0.1", false},
+		{"::1:3333", false},
+		{"[::1::2]:3333", false},
+		{"localhost:http", false},
+		{"localhost:70000", false},
+		{"bad_host:3333", false},
+		{"-leading.dash:3333", false},
+		{"unix:", false},
+	} {
+		err := validateAddr(tc.addr)
+		if tc.ok && err != nil {
+			t.Errorf("%q: %v", tc.addr, err)
+		}
+		if !tc.ok && err == nil {
+			t.Errorf("%q: accepted", tc.addr)
+		}
+	}
+}
+
+func TestNowPlayingIdle(t *testing.T) {
+	mc, mpv := newFakeMPV(t)
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := mc.NowPlaying()
+		errc <- err
+	}()
+	for i := 0; i < 3; i++ {
+		r := mpv.next()
+		mpv.send(fmt.Sprintf(`{"request_id": %s, "error": "property unavailable"}`, r.RequestID))
+	}
+	if err := <-errc; !errors.Is(err, ErrIdle) {
+		t.Errorf("got %v, want ErrIdle", err)
+	}
+}
+
+func TestSessionIdle(t *testing.T) {
+	mc, mpv := newFakeMPV(t)
+
+	// What an idle mpv has to say.
+	go func() {
+		for r := range mpv.reqs {
+			line := fmt.Sprintf(`{"request_id": %s, "error": "property unavailable"}`, r.RequestID)
+			switch r.Command[1] {
+			case "pause":
+				line = fmt.Sprintf(`{"data": false, "request_id": %s, "error": "success"}`, r.RequestID)
+			case "playlist":
+				line = fmt.Sprintf(`{"data": [], "request_id": %s, "error": "success"}`, r.RequestID)
+			}
+			if _, err := mpv.conn.Write([]byte(line + "\n")); err != nil {
+				return
+			}
+		}
+	}()
+
+	sess, err := mc.Session()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sess.Title != "" || len(sess.Playlist) != 0 {
+		t.Errorf("got %+v, want an empty session", sess)
+	}
+}
+
+func TestRepliesOutOfOrder(t *testing.T) {
+	mc, mpv := newFakeMPV(t)
+
+	const n = 32
+	res := make([]<-chan []byte, n)
+	for i := range res {
+		var err error
+		if res[i], err = mc.Command("get_property", fmt.Sprint("prop-", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	reqs := make([]request, n)
+	for i := range reqs {
+		reqs[i] = mpv.next()
+	}
+	rand.Shuffle(n, func(i, j int) { reqs[i], reqs[j] = reqs[j], reqs[i] })
+	for _, r := range reqs {
+		mpv.reply(r, fmt.Sprintf("%q", r.Command[1]))
+	}
+
+	for i, ch := range res {
+		data, err := replyData(recv(t, ch))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := fmt.Sprintf(`"prop-%d"`, i); string(data) != want {
+			t.Errorf("command %d got %s, want %s", i, data, want)
+		}
+	}
+}
+
+func TestOversizedReply(t *testing.T) {
+	mc, mpv := newFakeMPV(t)
+	mc.SetMaxLineSize(1024)
+
+	res, err := mc.Command("get_property", "track-list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mpv.reply(mpv.next(), fmt.Sprintf("%q", strings.Repeat("x", 64<<10)))
+	if _, err := replyData(recv(t, res)); err == nil || !strings.Contains(err.Error(), "reply too large") {
+		t.Errorf("got %v, want the reply to be too large", err)
+	}
+
+	// The monitor skips past it and carries on.
+	res, err = mc.Command("get_property", "pause")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mpv.reply(mpv.next(), "true")
+	if data, err := replyData(recv(t, res)); err != nil || string(data) != "true" {
+		t.Errorf("got %s, %v after the oversized reply", data, err)
+	}
+}
+
+func TestConcurrentClose(t *testing.T) {
+	mc, mpv := newFakeMPV(t)
+	mpv.serve()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				res, err := mc.Command("get_property", "pause")
+				if errors.Is(err, ErrClosed) {
+					return
+				}
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				<-res
+			}
+		}()
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			mc.Close()
+		}()
+	}
+	wg.Wait()
+
+	if _, err := mc.Command("get_property", "pause"); !errors.Is(err, ErrClosed) {
+		t.Errorf("got %v after Close, want ErrClosed", err)
+	}
+	closeWithin(t, mc, time.Second)
+}
+
+func BenchmarkStatus(b *testing.B) {
+	for _, n := range []int{1, 4} {
+		b.Run(fmt.Sprint("concurrency-", n), func(b *testing.B) {
+			mc, mpv := newFakeMPV(b)
+			mc.SetStatusConcurrency(n)
+
+			// Each reply takes a while, like a real mpv busy playing.
+			var mtx sync.Mutex
+			go func() {
+				for r := range mpv.reqs {
+					go func(r request) {
+						time.Sleep(200 * time.Microsecond)
+						data := "1.5"
+						if r.Command[1] == "pause" {
+							data = "false"
+						}
+						mtx.Lock()
+						defer mtx.Unlock()
+						fmt.Fprintf(mpv.conn, `{"data": %s, "request_id": %s, "error": "success"}`+"\n", data, r.RequestID)
+					}(r)
+				}
+			}()
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := mc.Status(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestMaxInflight(t *testing.T) {
+	mc, mpv := newFakeMPV(t)
+	mc.SetMaxInflight(2)
+
+	var reqs []request
+	var res []<-chan []byte
+	for i := 0; i < 2; i++ {
+		ch, err := mc.Command("get_property", "pause")
+		if err != nil {
+			t.Fatal(err)
+		}
+		res = append(res, ch)
+		reqs = append(reqs, mpv.next())
+	}
+	if _, err := mc.Command("get_property", "pause"); !errors.Is(err, ErrBusy) {
+		t.Fatalf("got %v over the limit, want ErrBusy", err)
+	}
+
+	// A reply frees up a slot.
+	mpv.reply(reqs[0], "false")
+	recv(t, res[0])
+	if _, err := mc.Command("get_property", "pause"); err != nil {
+		t.Errorf("got %v under the limit", err)
+	}
+	mpv.reply(reqs[1], "false")
+	mpv.reply(mpv.next(), "false")
+}
+
+// flakyMPV returns a client whose first connection fails every write, and a
+// fake mpv on the connection it gets after that.
+func flakyMPV(t *testing.T) (*MPVClient, *fakeMPV, chan net.Conn) {
+	a1, b1 := net.Pipe()
+	a2, b2 := net.Pipe()
+	t.Cleanup(func() { b1.Close() })
+
+	conns := make(chan net.Conn, 2)
+	conns <- failWrites{a1}
+	conns <- a2
+	mc := newMPVClient(func() (net.Conn, error) {
+		select {
+		case c := <-conns:
+			return c, nil
+		default:
+			return nil, ErrNoRedial
+		}
+	})
+	mc.SetRetryPolicy(RetryPolicy{Attempts: 3, Backoff: time.Millisecond})
+	t.Cleanup(func() { mc.Close() })
+	return mc, startFakeMPV(t, b2), conns
+}
+
+func TestRetryIdempotent(t *testing.T) {
+	mc, mpv, _ := flakyMPV(t)
+
+	res, err := mc.Command("get_property", "pause")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mpv.reply(mpv.next(), "true")
+	if data, err := replyData(recv(t, res)); err != nil || string(data) != "true" {
+		t.Errorf("got %s, %v from the retried command", data, err)
+	}
+}
+
+func TestNoRetryRelative(t *testing.T) {
+	mc, _, conns := flakyMPV(t)
+
+	if _, err := mc.Command("seek", 5); !errors.Is(err, errWriteFailed) {
+		t.Errorf("got %v, want the write error", err)
+	}
+	if len(conns) != 1 {
+		t.Error("a relative seek was tried again")
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	rp := RetryPolicy{Attempts: 2}
+	get := []byte(`{"command": ["get_property", "pause"]`)
+	add := []byte(`{"command": ["add", "volume", 5]`)
+
+	for _, tc := range []struct {
+		cmd     []byte
+		err     error
+		attempt int
+		want    bool
+	}{
+		{get, errWriteFailed, 1, true},
+		{get, errWriteFailed, 2, false},
+		{add, errWriteFailed, 1, false},
+		{get, ErrClosed, 1, false},
+		{get, ErrBusy, 1, false},
+		{get, ErrNoRedial, 1, false},
+	} {
+		if got := rp.retryable(tc.cmd, tc.err, tc.attempt); got != tc.want {
+			t.Errorf("%s after %v on attempt %d: got %v, want %v", tc.cmd, tc.err, tc.attempt, got, tc.want)
+		}
+	}
+}
+
+func TestFloatRequestID(t *testing.T) {
+	mc, mpv := newFakeMPV(t)
+
+	for _, form := range []string{"%s.0", "%se0"} {
+		res, err := mc.Command("get_property", "pause")
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := mpv.next()
+		mpv.send(fmt.Sprintf(`{"data": false, "request_id": `+form+`, "error": "success"}`, r.RequestID))
+		if data, err := replyData(recv(t, res)); err != nil || string(data) != "false" {
+			t.Errorf("%s: got %s, %v", form, data, err)
+		}
+	}
+}
+
+func TestParseRequestID(t *testing.T) {
+	for _, tc := range []struct {
+		raw string
+		id  uint32
+		ok  bool
+	}{
+		{"7", 7, true},
+		{"7.0", 7, true},
+		{"7.4", 7, true},
+		{"6.6", 7, true},
+		{"7e0", 7, true},
+		{"4294967295", 4294967295, true},
+
+		{"-1", 0, false},
+		{"4294967296", 0, false},
+		{"1e300", 0, false},
+		{`"7"`, 0, false},
+		{"", 0, false},
+	} {
+		id, err := parseRequestID([]byte(tc.raw))
+		if tc.ok && (err != nil || id != tc.id) {
+			t.Errorf("%q: got %d, %v, want %d", tc.raw, id, err, tc.id)
+		}
+		if !tc.ok && err == nil {
+			t.Errorf("%q: got %d, want an error", tc.raw, id)
+		}
+	}
+}
+
+func TestLoadFileWithOptsIndex(t *testing.T) {
+	for _, tc := range []struct {
+		version string
+		args    int
+	}{
+		{"mpv 0.37.0", 4},
+		{"mpv v0.38.0", 5},
+		{"mpv 0.39.0-dev", 5},
+	} {
+		mc, mpv := newFakeMPV(t)
+
+		errc := make(chan error, 1)
+		go func() {
+			_, err := mc.LoadFileWithOpts("a.mkv", "replace", map[string]string{"start": "10"})
+			errc <- err
+		}()
+		mpv.reply(mpv.next(), fmt.Sprintf("%q", tc.version))
+		r := mpv.next()
+		if err := <-errc; err != nil {
+			t.Fatal(err)
+		}
+
+		if len(r.Command) != tc.args || r.Command[len(r.Command)-1] != "start=10" {
+			t.Errorf("%s: sent %v", tc.version, r.Command)
+		}
+	}
+}
+
+func TestSeekMuterBurst(t *testing.T) {
+	mc, mpv := newFakeMPV(t)
+	sm := newSeekMuter(mc, 100*time.Millisecond)
+	seek := sm.wrap(func(float64) (<-chan []byte, error) { return mc.Command("seek", 1) })
+
+	seeked := make(chan struct{})
+	go func() {
+		defer close(seeked)
+		for i := 0; i < 20; i++ {
+			if _, err := seek(1); err != nil {
+				t.Error(err)
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}()
+
+	var cmds []string
+	for {
+		r := mpv.next()
+		cmd := fmt.Sprint(r.Command)
+		cmds = append(cmds, cmd)
+		if cmd == "[get_property mute]" {
+			mpv.reply(r, "false")
+		} else {
+			mpv.reply(r, "null")
+		}
+		if cmd == "[set_property mute false]" {
+			break
+		}
+	}
+	<-seeked
+
+	want := append([]string{"[get_property mute]", "[set_property mute true]"}, strings.Split(strings.Repeat("[seek 1],", 20), ",")...)
+	want[len(want)-1] = "[set_property mute false]"
+	if strings.Join(cmds, " ") != strings.Join(want, " ") {
+		t.Errorf("sent %v, want %v", cmds, want)
+	}
+}
+
+func TestQueryKey(t *testing.T) {
+	for _, q := range []string{"name=Ctrl+RIGHT", "name=Ctrl%2BRIGHT", "name=Ctrl++", "name=a"} {
+		v, err := url.ParseQuery(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := checkKey(queryKey(v.Get("name"))); err != nil {
+			t.Errorf("%s: %v", q, err)
+		}
+	}
+}
+
+func TestReplyTimeout(t *testing.T) {
+	mc, mpv := newFakeMPV(t)
+	mc.SetReplyTimeout(20 * time.Millisecond)
+
+	res, err := mc.Command("get_property", "pause")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := mpv.next()
+	if _, err := replyData(recv(t, res)); !errors.Is(err, ErrTimeout) {
+		t.Errorf("got %v, want ErrTimeout", err)
+	}
+
+	// The reply coming late is dropped, and Close isn't left waiting for it.
+	mpv.reply(r, "false")
+	closeWithin(t, mc, time.Second)
+}
+
+func TestCommandAfterMonitorExit(t *testing.T) {
+	a, b := net.Pipe()
+	mc := NewMPVClientConn(a)
+	defer mc.Close()
+
+	mc.i2cMtx.Lock()
+	done := mc.monitorDone
+	mc.i2cMtx.Unlock()
+
+	b.Close()
+	// Wait for the monitor to see it.
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the monitor didn't stop")
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := mc.Command("get_property", "pause")
+		errc <- err
+	}()
+	select {
+	case err := <-errc:
+		if !errors.Is(err, ErrNotConnected) {
+			t.Errorf("got %v, want ErrNotConnected", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("command hung on a dead connection")
+	}
+}
+
+func TestBrowse(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	for _, d := range []string{"films", "films/old"} {
+		if err := os.Mkdir(filepath.Join(root, d), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "out")); err != nil {
+		t.Skip("can't make symlinks:", err)
+	}
+	if err := os.Symlink(filepath.Join(root, "films"), filepath.Join(root, "in")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		dir  string
+		want string
+	}{
+		{"", "films,in,out"},
+		{"films", "old"},
+		{"in", "old"},
+		{"../..", "films,in,out"},
+		{"films/../../..", "films,in,out"},
+	} {
+		entries, err := Browse(root, tc.dir)
+		if err != nil {
+			t.Errorf("%q: %v", tc.dir, err)
+			continue
+		}
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name)
+		}
+		if got := strings.Join(names, ","); got != tc.want {
+			t.Errorf("%q: got %s, want %s", tc.dir, got, tc.want)
+		}
+	}
+
+	if _, err := Browse(root, "out"); !errors.Is(err, ErrBadArgument) {
+		t.Errorf("followed a symlink out of the root: %v", err)
+	}
+
+	// With the filesystem root as the browse root, everything is under it.
+	real, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsRoot := filepath.VolumeName(real) + string(filepath.Separator)
+	rel, err := filepath.Rel(fsRoot, real)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Browse(fsRoot, filepath.ToSlash(rel)); err != nil {
+		t.Errorf("browsing %s under %s: %v", rel, fsRoot, err)
+	}
+}
+
+func TestLogMessagesKeptApart(t *testing.T) {
+	mc, mpv := newFakeMPV(t)
+	events, unsub := mc.Subscribe()
+	defer unsub()
+	logs, unsubLogs := mc.SubscribeLogs()
+	defer unsubLogs()
+
+	// More than a subscriber has room for, so any of them in events would
+	// push out the event after them.
+	for i := 0; i < 32; i++ {
+		mpv.send(`{"event": "log-message", "prefix": "cplayer", "level": "info", "text": "hi\n"}`)
+	}
+	mpv.send(`{"event": "file-loaded"}`)
+
+	if ev := recv(t, events); !strings.Contains(string(ev), "file-loaded") {
+		t.Errorf("subscriber got %s, want file-loaded", ev)
+	}
+	if ev := recv(t, logs); !strings.Contains(string(ev), "log-message") {
+		t.Errorf("log subscriber got %s", ev)
+	}
+
+	mc.subsMtx.Lock()
+	history := mc.history.list()
+	mc.subsMtx.Unlock()
+	if len(history) != 1 {
+		t.Errorf("history has %d events, want just file-loaded", len(history))
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/buger/jsonparser"
+)
+
+// ObserveProperty asks mpv to send a "property-change" event every time name
+// changes, and once straight away with the current value. The events carry
+// the returned id, which is also what UnobserveProperty takes.
+func (mc *MPVClient) ObserveProperty(name string) (int64, error) {
+	id := mc.observeID.Add(1)
+	res, err := mc.Command("observe_property", id, name)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := replyData(<-res); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+func (mc *MPVClient) UnobserveProperty(id int64) error {
+	res, err := mc.Command("unobserve_property", id)
+	if err != nil {
+		return err
+	}
+	_, err = replyData(<-res)
+	return err
+}
+
+func isPropertyChange(event []byte) bool {
+	name, _ := jsonparser.GetString(event, "event")
+	return name == "property-change"
+}
+
+// observedBy tells if event is a property change for one of ids.
+func observedBy(event []byte, ids map[int64]bool) bool {
+	if !isPropertyChange(event) {
+		return false
+	}
+	id, err := jsonparser.GetInt(event, "id")
+	return err == nil && ids[id]
+}
+
+// ObserveMany observes every property in names, and returns a channel for
+// each that gets its property-change events. As with Subscribe, events are
+// dropped for a channel that isn't kept up with. If one of them can't be
+// observed, the ones before it are unobserved again.
+//
+// The channels are closed by UnobserveAll, which is the only way to stop
+// these observations.
+func (mc *MPVClient) ObserveMany(names []string) (map[string]<-chan []byte, error) {
+	chs := make(map[string]<-chan []byte, len(names))
+	var ids []int64
+	for _, name := range names {
+		if _, ok := chs[name]; ok {
+			continue
+		}
+
+		// Registered before asking, as mpv sends the current value
+		// straight away.
+		id := mc.observeID.Add(1)
+		ch := make(chan []byte, 16)
+		mc.observedMtx.Lock()
+		mc.observed[id] = ch
+		mc.observedMtx.Unlock()
+
+		res, err := mc.Command("observe_property", id, name)
+		if err == nil {
+			_, err = replyData(<-res)
+		}
+		if err != nil {
+			mc.unobserve(append(ids, id))
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		ids = append(ids, id)
+		chs[name] = ch
+	}
+	return chs, nil
+}
+
+// UnobserveAll stops everything observed through ObserveMany, and closes the
+// channels it handed out. The first error from mpv is returned, but all of
+// them are tried.
+func (mc *MPVClient) UnobserveAll() error {
+	mc.observedMtx.Lock()
+	ids := make([]int64, 0, len(mc.observed))
+	for id := range mc.observed {
+		ids = append(ids, id)
+	}
+	mc.observedMtx.Unlock()
+
+	return mc.unobserve(ids)
+}
+
+// unobserve stops observing ids, which must be from ObserveMany.
+func (mc *MPVClient) unobserve(ids []int64) error {
+	mc.observedMtx.Lock()
+	for _, id := range ids {
+		if ch, ok := mc.observed[id]; ok {
+			delete(mc.observed, id)
+			close(ch)
+		}
+	}
+	mc.observedMtx.Unlock()
+
+	var first error
+	for _, id := range ids {
+		if err := mc.UnobserveProperty(id); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// deliverObserved hands event to the ObserveMany channel it is for, if any.
+func (mc *MPVClient) deliverObserved(event []byte) {
+	if !isPropertyChange(event) {
+		return
+	}
+	id, err := jsonparser.GetInt(event, "id")
+	if err != nil {
+		return
+	}
+
+	mc.observedMtx.Lock()
+	defer mc.observedMtx.Unlock()
+	if ch, ok := mc.observed[id]; ok {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
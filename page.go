@@ -0,0 +1,134 @@
+package main
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+
+	"github.com/pressly/chi"
+)
+
+// control is a button on the root page, served to POST at /api/<name>.
+type control struct {
+	Name string
+	h    http.HandlerFunc
+}
+
+func simple(name string, f func(*MPVClient) (<-chan []byte, error)) control {
+	return control{Name: name, h: basicHandler(f)}
+}
+
+// playback is a control that does nothing unless a file is loaded.
+func playback(name string, f func(*MPVClient) (<-chan []byte, error)) control {
+	return control{Name: name, h: playing(basicHandler(f))}
+}
+
+// controlGroup is a set of controls shown together. A directional group is
+// two controls going opposite ways, like chapterPrev and chapterNext, and is
+// shown as a single pair of arrows around its label.
+type controlGroup struct {
+	Label       string
+	Controls    []control
+	Directional bool
+}
+
+func group(cs ...control) controlGroup { return controlGroup{Controls: cs} }
+
+// direction makes a directional group. By default the controls are called
+// label+"Prev" and label+"Next", other names can be given after the
+// functions.
+func direction(label string, back, forth func(*MPVClient) (<-chan []byte, error), names ...string) controlGroup {
+	if len(names) != 2 {
+		names = []string{label + "Prev", label + "Next"}
+	}
+	return controlGroup{
+		Label:       label,
+		Controls:    []control{simple(names[0], back), simple(names[1], forth)},
+		Directional: true,
+	}
+}
+
+// controlTable is everything on the root page, in order.
+func controlTable() []controlGroup {
+	return []controlGroup{
+		group(playback("pauseToggle", (*MPVClient).PauseToggle)),
+		group(
+			simple("oscOff", (*MPVClient).OSCOff),
+			simple("oscOn", (*MPVClient).OSCOn),
+			control{Name: "oscStats", h: compositeHandler((*MPVClient).OSCOn, (*MPVClient).ToggleStats)},
+		),
+		group(
+			simple("borderToggle", (*MPVClient).ToggleBorder),
+			simple("borderOn", func(mc *MPVClient) (<-chan []byte, error) { return mc.SetBorder(true) }),
+			simple("borderOff", func(mc *MPVClient) (<-chan []byte, error) { return mc.SetBorder(false) }),
+		),
+		direction("playlist", (*MPVClient).PlaylistPrev, (*MPVClient).PlaylistNext),
+		direction("chapter", (*MPVClient).ChapterPrev, (*MPVClient).ChapterNext).whilePlaying(),
+		direction("press", (*MPVClient).PressLeft, (*MPVClient).PressRight, "pressLeft", "pressRight"),
+	}
+}
+
+// whilePlaying makes every control in g one that does nothing unless a file is
+// loaded.
+func (g controlGroup) whilePlaying() controlGroup {
+	cs := make([]control, len(g.Controls))
+	for i, c := range g.Controls {
+		cs[i] = control{Name: c.Name, h: playing(c.h)}
+	}
+	g.Controls = cs
+	return g
+}
+
+func registerControls(r chi.Router, groups []controlGroup) {
+	for _, g := range groups {
+		for _, c := range g.Controls {
+			r.Post("/api/"+c.Name, c.h)
+		}
+	}
+}
+
+var rootTmpl = template.Must(template.New("root").Parse(`
+		<html>
+		<head>
+			<meta charset="utf-8">
+			<meta http-equiv="x-ua-compatible" content="ie=edge">
+			<meta name="viewport" content="width=device-width, initial-scale=1">
+			<style>form { display: inline; }</style>
+		</head>
+		<body>
+			<h1>Controls</h1>
+			<select id="instance" onchange="fetch('/api/instances/select?name=' + encodeURIComponent(this.value), {method: 'POST'}).then(function() { location.reload() })"></select>
+			<script>
+				fetch('/api/instances').then(function(r) { return r.json() }).then(function(is) {
+					var sel = document.getElementById('instance');
+					is.forEach(function(i) {
+						var o = document.createElement('option');
+						o.value = i.name;
+						o.text = i.index + ': ' + (i.friendly || i.name);
+						o.selected = i.active;
+						sel.appendChild(o);
+					});
+				});
+			</script>
+			<ul>
+			{{- range $i, $g := . }}
+				{{ if $i }}<li></li>{{ end }}
+				{{ if $g.Directional -}}
+				<li><form method="post" action="/api/{{ (index $g.Controls 0).Name }}"><button>&laquo;</button></form> {{ $g.Label }} <form method="post" action="/api/{{ (index $g.Controls 1).Name }}"><button>&raquo;</button></form></li>
+				{{- else }}{{ range $g.Controls }}
+				<li><form method="post" action="/api/{{ .Name }}"><button>{{ .Name }}</button></form></li>
+				{{- end }}{{ end }}
+			{{- end }}
+			</ul>
+		</body>
+		</html>
+`))
+
+// rootHandler serves the controls.
+func rootHandler(groups []controlGroup) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := rootTmpl.Execute(w, groups); err != nil {
+			log.Println(err)
+		}
+	}
+}
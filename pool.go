@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+)
+
+// handlerPool bounds how many requests are being handled at once, so a burst
+// of them can't pile up commands behind the writer. Requests over the limit
+// get a 503 straight away rather than waiting.
+type handlerPool struct {
+	slots chan struct{}
+	// Paths of long lived requests, like event streams, which would
+	// otherwise hold a slot for as long as they are open.
+	exempt map[string]bool
+}
+
+// newHandlerPool makes a pool of size n. With n of 0 or less there is no
+// limit.
+func newHandlerPool(n int, exempt ...string) *handlerPool {
+	p := &handlerPool{exempt: map[string]bool{}}
+	if n > 0 {
+		p.slots = make(chan struct{}, n)
+	}
+	for _, path := range exempt {
+		p.exempt[path] = true
+	}
+	return p
+}
+
+func (p *handlerPool) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if p.slots == nil || p.exempt[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		select {
+		case p.slots <- struct{}{}:
+			defer func() { <-p.slots }()
+			next.ServeHTTP(w, r)
+		default:
+			httpError(w, r, ErrBusy)
+		}
+	})
+}
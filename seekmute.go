@@ -0,0 +1,121 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// seekMuter mutes mpv while seeks keep coming, so scrubbing doesn't sound
+// like a broken record. Once no seek has come for settle, the mute is put
+// back the way it was.
+type seekMuter struct {
+	mc     *MPVClient
+	settle time.Duration
+
+	// muteMtx is held while asking mpv about the mute or changing it, so
+	// the unmute at the end of one burst is done before the next burst
+	// looks at the mute. It is taken before mtx, never after.
+	muteMtx sync.Mutex
+
+	mtx   sync.Mutex
+	timer *time.Timer
+	// Bumped on every seek, a timer only settles the burst if no seek
+	// has come since it was set.
+	gen uint64
+	// muting is set from the first seek of a burst until it settles, and
+	// ready once mpv has been muted and the timer can run.
+	muting, ready bool
+	// If mpv was already muted when the seeking started, so we leave it be.
+	wasMuted bool
+}
+
+func newSeekMuter(mc *MPVClient, settle time.Duration) *seekMuter {
+	return &seekMuter{mc: mc, settle: settle}
+}
+
+// wrap returns f, muting around it. With no settle time f is returned as is.
+func (sm *seekMuter) wrap(f func(float64) (<-chan []byte, error)) func(float64) (<-chan []byte, error) {
+	if sm.settle <= 0 {
+		return f
+	}
+	return func(v float64) (<-chan []byte, error) {
+		sm.seeking()
+		return f(v)
+	}
+}
+
+func (sm *seekMuter) seeking() {
+	sm.mtx.Lock()
+	sm.gen++
+	start := !sm.muting
+	sm.muting = true
+	if sm.ready {
+		sm.arm()
+	}
+	sm.mtx.Unlock()
+
+	// Only the first seek of a burst talks to mpv, the rest just push
+	// the timer back.
+	if !start {
+		return
+	}
+
+	sm.muteMtx.Lock()
+	data, err := sm.mc.GetProperty("mute")
+	// If we can't tell, leave the mute alone at the end too.
+	wasMuted := err != nil || string(data) == "true"
+	if err != nil {
+		log.Println(err)
+	} else if !wasMuted {
+		sm.setMute(true)
+	}
+	sm.muteMtx.Unlock()
+
+	sm.mtx.Lock()
+	defer sm.mtx.Unlock()
+	sm.wasMuted = wasMuted
+	sm.ready = true
+	sm.arm()
+}
+
+// arm (re)starts the timer for the current seek. It must be called with mtx
+// held.
+func (sm *seekMuter) arm() {
+	if sm.timer != nil {
+		sm.timer.Stop()
+	}
+	gen := sm.gen
+	sm.timer = time.AfterFunc(sm.settle, func() { sm.settled(gen) })
+}
+
+func (sm *seekMuter) settled(gen uint64) {
+	sm.muteMtx.Lock()
+	defer sm.muteMtx.Unlock()
+
+	sm.mtx.Lock()
+	// A seek came after this timer was set, and a newer timer is running.
+	// Stop only keeps a timer from firing, not one that already has.
+	if gen != sm.gen {
+		sm.mtx.Unlock()
+		return
+	}
+	sm.timer = nil
+	sm.muting, sm.ready = false, false
+	restore := !sm.wasMuted
+	sm.mtx.Unlock()
+
+	if restore {
+		sm.setMute(false)
+	}
+}
+
+func (sm *seekMuter) setMute(on bool) {
+	res, err := sm.mc.SetProperty("mute", on)
+	if err == nil {
+		_, err = replyData(<-res)
+	}
+	if err != nil {
+		log.Println(err)
+	}
+}
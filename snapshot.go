@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// SnapshotProperties are what SnapshotState takes when not told otherwise,
+// the settings worth comparing side by side.
+var SnapshotProperties = []string{
+	"volume", "mute", "speed",
+	"brightness", "contrast", "saturation", "gamma", "hue",
+	"sub-scale", "sub-pos", "sub-delay", "audio-delay",
+}
+
+// SnapshotState reads props, or SnapshotProperties if there are none, so they
+// can be put back with RestoreState. Properties mpv can't give right now are
+// left out.
+func (mc *MPVClient) SnapshotState(props []string) (map[string]json.RawMessage, error) {
+	if len(props) == 0 {
+		props = SnapshotProperties
+	}
+
+	state := make(map[string]json.RawMessage, len(props))
+	for _, name := range props {
+		data, err := mc.GetProperty(name)
+		if unavailable(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		state[name] = json.RawMessage(data)
+	}
+	return state, nil
+}
+
+// RestoreState sets every property in state. Some can't be set, like read only
+// ones that ended up in a snapshot, so it carries on past them and returns
+// why each of those failed. The error is only for not getting through to mpv.
+func (mc *MPVClient) RestoreState(state map[string]json.RawMessage) (map[string]string, error) {
+	failed := map[string]string{}
+	for name, v := range state {
+		res, err := mc.SetProperty(name, v)
+		if err != nil {
+			return failed, err
+		}
+		if _, err := replyData(<-res); err != nil {
+			failed[name] = err.Error()
+		}
+	}
+	return failed, nil
+}
+
+// ApplyProfile sets every property in props, in name order, carrying on past
+// the ones that fail. It returns the names that were set and why the others
+// weren't.
+func (mc *MPVClient) ApplyProfile(props map[string]interface{}) (applied []string, failed map[string]error) {
+	names := make([]string, 0, len(props))
+	for name := range props {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	failed = map[string]error{}
+	for _, name := range names {
+		res, err := mc.SetProperty(name, props[name])
+		if err == nil {
+			_, err = replyData(<-res)
+		}
+		if err != nil {
+			failed[name] = err
+			continue
+		}
+		applied = append(applied, name)
+	}
+	return applied, failed
+}
+
+// ApplyProfileOrRollback is ApplyProfile, except that if anything fails the
+// properties that were set are put back the way they were, so the profile is
+// applied either whole or not at all. Properties that couldn't be read
+// beforehand can't be put back, and neither can ones mpv won't take their old
+// value for; those are in the error.
+func (mc *MPVClient) ApplyProfileOrRollback(props map[string]interface{}) (applied []string, failed map[string]error, err error) {
+	names := make([]string, 0, len(props))
+	for name := range props {
+		names = append(names, name)
+	}
+	before, err := mc.SnapshotState(names)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	applied, failed = mc.ApplyProfile(props)
+	if len(failed) == 0 {
+		return applied, failed, nil
+	}
+
+	var lost []string
+	undo := map[string]json.RawMessage{}
+	for _, name := range applied {
+		if v, ok := before[name]; ok {
+			undo[name] = v
+		} else {
+			lost = append(lost, name)
+		}
+	}
+	notRestored, err := mc.RestoreState(undo)
+	if err != nil {
+		return applied, failed, fmt.Errorf("rolling back: %w", err)
+	}
+	for name := range notRestored {
+		lost = append(lost, name)
+	}
+	if len(lost) > 0 {
+		sort.Strings(lost)
+		return applied, failed, fmt.Errorf("couldn't roll back %v", lost)
+	}
+	return nil, failed, nil
+}
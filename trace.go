@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/http"
+)
+
+type traceKey struct{}
+
+// traceMiddleware gives every request an id, so everything logged on its
+// behalf can be picked out of the log. It is also sent back in a header.
+func traceMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b := make([]byte, 8)
+		if _, err := rand.Read(b); err != nil {
+			log.Println(err)
+		}
+		id := hex.EncodeToString(b)
+
+		w.Header().Set("X-Trace-Id", id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), traceKey{}, id)))
+	})
+}
+
+// tracef logs with the trace id of r in front.
+func tracef(r *http.Request, format string, v ...interface{}) {
+	id, _ := r.Context().Value(traceKey{}).(string)
+	if id == "" {
+		id = "-"
+	}
+	log.Printf("[%s] "+format, append([]interface{}{id}, v...)...)
+}
+
+type clientKey struct{}
+
+// withClient has handlers send their commands through mc, tagged with the
+// trace id of the request they are for. It must come after traceMiddleware.
+func withClient(mc *MPVClient) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id, _ := r.Context().Value(traceKey{}).(string)
+			ctx := context.WithValue(r.Context(), clientKey{}, mc.Traced(id))
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// requestClient is the client set by withClient for r.
+func requestClient(r *http.Request) *MPVClient {
+	return r.Context().Value(clientKey{}).(*MPVClient)
+}
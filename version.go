@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+)
+
+// The mpv releases this has been tried with, as major.minor. Others will most
+// likely work, but replies and events may differ.
+var (
+	minTestedVersion = [2]int{0, 32}
+	maxTestedVersion = [2]int{0, 38}
+)
+
+// MPVVersion is what "mpv-version" says, and if that is a release we have
+// tested with.
+type MPVVersion struct {
+	Version string `json:"mpv-version"`
+	Tested  bool   `json:"tested"`
+}
+
+var versionRe = regexp.MustCompile(`^mpv v?(\d+)\.(\d+)`)
+
+func (mc *MPVClient) MPVVersion() (*MPVVersion, error) {
+	v, err := mc.getString("mpv-version")
+	if err != nil {
+		return nil, err
+	}
+	return &MPVVersion{Version: v, Tested: testedVersion(v)}, nil
+}
+
+// parseVersion reads major.minor from an "mpv-version".
+func parseVersion(v string) ([2]int, bool) {
+	m := versionRe.FindStringSubmatch(v)
+	if m == nil {
+		return [2]int{}, false
+	}
+	major, _ := strconv.Atoi(m[1])
+	minor, _ := strconv.Atoi(m[2])
+	return [2]int{major, minor}, true
+}
+
+// testedVersion is false for versions we can't read, like some git builds.
+func testedVersion(v string) bool {
+	mm, ok := parseVersion(v)
+	return ok && !less(mm, minTestedVersion) && !less(maxTestedVersion, mm)
+}
+
+// loadfileIndexVersion is the first release where loadfile takes an
+// insertion index before the options.
+var loadfileIndexVersion = [2]int{0, 38}
+
+// loadfileTakesIndex is true if mpv wants the index argument. Versions we
+// can't read are taken to be recent git builds, which do.
+func (mc *MPVClient) loadfileTakesIndex() (bool, error) {
+	v, err := mc.getString("mpv-version")
+	if err != nil {
+		return false, err
+	}
+	mm, ok := parseVersion(v)
+	return !ok || !less(mm, loadfileIndexVersion), nil
+}
+
+func less(a, b [2]int) bool { return a[0] < b[0] || a[0] == b[0] && a[1] < b[1] }
+
+// checkVersion warns if mpv isn't a version we have tested with.
+func (mc *MPVClient) checkVersion() {
+	v, err := mc.MPVVersion()
+	if err != nil {
+		log.Printf("Couldn't get the mpv version: %v", err)
+		return
+	}
+	if !v.Tested {
+		log.Printf("Warning: %q is not a version we have tested with (%s to %s), some things may not work", v.Version, versionString(minTestedVersion), versionString(maxTestedVersion))
+	}
+}
+
+func versionString(v [2]int) string { return fmt.Sprintf("%d.%d", v[0], v[1]) }
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/buger/jsonparser"
+)
+
+// watchProperty observes name and calls f with the data of every change, from
+// a single goroutine. The property is observed again each time we reconnect,
+// as mpv forgets it with the connection. f gets nil when the property has no
+// value.
+func (mc *MPVClient) watchProperty(name string, f func(data []byte)) {
+	mc.OnEvent("property-change", func(event []byte) {
+		if n, _ := jsonparser.GetString(event, "name"); n != name {
+			return
+		}
+		data, _, _, _ := jsonparser.Get(event, "data")
+		f(data)
+	})
+
+	observe := func() {
+		if _, err := mc.ObserveProperty(name); err != nil {
+			log.Printf("Couldn't observe %s: %v", name, err)
+		}
+	}
+	states := mc.StateChanges()
+	go func() {
+		observe()
+		for {
+			select {
+			case cs := <-states:
+				if cs == Connected {
+					go observe()
+				}
+			case <-mc.quit:
+				return
+			}
+		}
+	}()
+}
+
+// WatchBuffering sends a "buffering" event to subscribers whenever
+// "paused-for-cache" starts or stops, like
+//
+//	{"event": "buffering", "buffering": true}
+//
+// so frontends can show a spinner without polling.
+func (mc *MPVClient) WatchBuffering() {
+	buffering := false
+	mc.watchProperty("paused-for-cache", func(data []byte) {
+		// Others may observe it too, so we only pass on real changes.
+		on := string(data) == "true"
+		if on == buffering {
+			return
+		}
+		buffering = on
+
+		ev, _ := json.Marshal(map[string]interface{}{"event": "buffering", "buffering": on})
+		mc.publish(ev)
+	})
+}
+
+// WatchPlaylist sends a "playlist" event to subscribers with the whole
+// playlist every time it changes, like
+//
+//	{"event": "playlist", "playlist": [{"filename": "a.mkv", "current": true}]}
+//
+// so a queue view can keep itself up to date.
+func (mc *MPVClient) WatchPlaylist() {
+	mc.watchProperty("playlist", func(data []byte) {
+		entries := []PlaylistEntry{}
+		if data != nil {
+			if err := json.Unmarshal(data, &entries); err != nil {
+				log.Printf("Couldn't decode the playlist: %v", err)
+				return
+			}
+		}
+
+		ev, _ := json.Marshal(map[string]interface{}{"event": "playlist", "playlist": entries})
+		mc.publish(ev)
+	})
+}
+
+// WatchSeeking keeps track of the "seeking" property, for Seeking.
+func (mc *MPVClient) WatchSeeking() {
+	mc.watchProperty("seeking", func(data []byte) {
+		mc.seeking.Store(string(data) == "true")
+	})
+}
+
+// Seeking is true while mpv is in the middle of a seek. It is always false
+// unless WatchSeeking has been called.
+func (mc *MPVClient) Seeking() bool { return mc.seeking.Load() }
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"time"
+)
+
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// webhook makes an OnEvent callback that POSTs the event to url. Each one is
+// sent from its own goroutine, so a slow receiver doesn't hold up the events
+// after it. Failures are only logged.
+func webhook(url string) func(event []byte) {
+	return func(event []byte) {
+		go func() {
+			resp, err := webhookClient.Post(url, "application/json", bytes.NewReader(event))
+			if err != nil {
+				log.Printf("Webhook %s failed: %v", url, err)
+				return
+			}
+			resp.Body.Close()
+			if resp.StatusCode/100 != 2 {
+				log.Printf("Webhook %s answered %s", url, resp.Status)
+			}
+		}()
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/buger/jsonparser"
+)
+
+// How many commands can wait for the writer in each queue before senders
+// block.
+const writeQueueLen = 64
+
+// ErrClosed is returned for commands sent after Close was called.
+var ErrClosed = errors.New("client is closed")
+
+type writeReq struct {
+	cmd   []byte
+	trace string
+	res   chan writeRes
+}
+
+type writeRes struct {
+	ch  <-chan []byte
+	err error
+}
+
+// writer is the only goroutine that writes to mpv. Anything in the high
+// priority queue is always written before the low priority one is looked at,
+// so a pause or stop doesn't get stuck behind a pile of relative seeks.
+func (mc *MPVClient) writer() {
+	for {
+		var req writeReq
+		select {
+		case req = <-mc.high:
+		default:
+			select {
+			case req = <-mc.high:
+			case req = <-mc.low:
+			case <-mc.quit:
+				return
+			}
+		}
+
+		ch, err := mc.write(req.cmd, req.trace)
+		req.res <- writeRes{ch: ch, err: err}
+	}
+}
+
+// Priority classifies commands for the writer. A command is high priority if
+// its name is in the set, or if it acts on a property (set_property, cycle,
+// add and friends take the property as their first argument) whose name is.
+// Everything else is low priority.
+type Priority struct {
+	names map[string]bool
+}
+
+// DefaultPriority lets control commands jump ahead of adjustments.
+var DefaultPriority = NewPriority("stop", "quit", "quit-watch-later", "pause")
+
+func NewPriority(names ...string) *Priority {
+	p := &Priority{names: make(map[string]bool)}
+	for _, n := range names {
+		if n != "" {
+			p.names[n] = true
+		}
+	}
+	return p
+}
+
+// Names returns the command and property names p treats as high priority.
+func (p *Priority) Names() []string {
+	names := make([]string, 0, len(p.names))
+	for n := range p.names {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (p *Priority) high(cmd []byte) bool {
+	name, prop := commandNames(cmd)
+	return p.names[name] || p.names[prop]
+}
+
+// commandNames returns the name of cmd, and its first argument if that is a
+// string, which for property commands is the property.
+func commandNames(cmd []byte) (name, prop string) {
+	// The command is still open for the request_id, close it so it parses.
+	full := append(cmd[:len(cmd):len(cmd)], '}')
+
+	name, _ = jsonparser.GetString(full, "command", "[0]")
+	prop, _ = jsonparser.GetString(full, "command", "[1]")
+	return name, prop
+}
+
+// IdempotentCommands are the commands that do the same thing no matter how
+// many times they are sent, and so can be retried when writing them fails.
+// Relative ones like "add", "cycle" and "seek" are not in here.
+var IdempotentCommands = map[string]bool{
+	"get_property": true,
+	"set_property": true,
+	"expand-text":  true,
+	"get_version":  true,
+	"client_name":  true,
+	"get_time_us":  true,
+}
+
+// RetryPolicy says how many times an idempotent command is tried before
+// giving up on writing it, and how long to wait before the first retry. The
+// wait doubles after every retry.
+type RetryPolicy struct {
+	Attempts int
+	Backoff  time.Duration
+}
+
+func (rp RetryPolicy) retryable(cmd []byte, err error, attempt int) bool {
+	if attempt >= rp.Attempts {
+		return false
+	}
+	// These aren't going to go away by trying again.
+	if errors.Is(err, ErrClosed) || errors.Is(err, ErrBusy) || errors.Is(err, ErrNoRedial) {
+		return false
+	}
+	name, _ := commandNames(cmd)
+	return IdempotentCommands[name]
+}
+
+func (rp RetryPolicy) backoff(attempt int) time.Duration {
+	return rp.Backoff << uint(attempt-1)
+}